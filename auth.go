@@ -0,0 +1,453 @@
+package summon
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//errAuthChallenge is returned when the server asks for authentication, the request is done again with the credentials.
+//it is also returned when the digest nonce has expired
+var errAuthChallenge = errors.New("server sent a new authentication challenge")
+
+//credentials used for authenticating with the server, these are never written to the meta file or the logs
+type credentials struct {
+	username string
+	password string
+	bearer   string
+	netrc    string      //path of the netrc file used when no credentials are passed, empty means netrc is not used
+	digest   *digestAuth //set once the server asks for digest authentication
+	basic    bool        //set once the server asks for basic authentication
+}
+
+//digestAuth is the digest challenge sent by the server, RFC 7616
+type digestAuth struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	userhash  bool
+	nc        int //number of requests done with the nonce
+	sync.Mutex
+}
+
+//setCredentials moves the credentials in the url to the downloader and looks up the netrc file if no credentials are passed
+func (sum *Downloader) setCredentials() error {
+
+	u, err := url.Parse(sum.uri)
+	if err != nil {
+		return err
+	}
+
+	if u.User != nil {
+		if sum.auth.username == "" {
+			sum.auth.username = u.User.Username()
+			sum.auth.password, _ = u.User.Password()
+		}
+		u.User = nil
+		sum.uri = u.String()
+	}
+
+	if sum.auth.username != "" || sum.auth.bearer != "" || sum.auth.netrc == "" {
+		return nil
+	}
+
+	login, password, err := netrcLookup(sum.auth.netrc, u.Hostname())
+	if err != nil {
+		return err
+	}
+
+	if login != "" {
+		sum.debug.Printf("Using credentials from %v for %v", sum.auth.netrc, u.Hostname())
+		sum.auth.username, sum.auth.password = login, password
+	}
+
+	return nil
+}
+
+//setAuthorization adds the Authorization header to the request, a header passed by the user is not replaced.
+//The username and password are sent only after the server asks for them so that the password is not sent in clear text
+//to a server which wants digest authentication
+func (sum *Downloader) setAuthorization(request *http.Request) error {
+
+	if request.Header.Get("Authorization") != "" {
+		return nil
+	}
+
+	sum.RLock()
+	digest, basic := sum.auth.digest, sum.auth.basic
+	sum.RUnlock()
+
+	switch {
+	case sum.auth.bearer != "":
+		request.Header.Set("Authorization", "Bearer "+sum.auth.bearer)
+	case digest != nil:
+		h, err := digest.authorization(sum.auth.username, sum.auth.password, request.Method, request.URL.RequestURI())
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Authorization", h)
+	case basic:
+		request.SetBasicAuth(sum.auth.username, sum.auth.password)
+	}
+
+	return nil
+}
+
+//handleChallenge reads the challenge of a 401 response, digest is used over basic when the server offers both.
+//it returns true if the request should be tried again with the credentials
+func (sum *Downloader) handleChallenge(response *http.Response) bool {
+
+	if response.StatusCode != http.StatusUnauthorized || sum.auth.username == "" || sum.auth.bearer != "" {
+		return false
+	}
+
+	//the credentials are not sent to the host the server redirected to
+	if u, err := url.Parse(sum.uri); err != nil || response.Request.URL.Host != u.Host {
+		return false
+	}
+
+	var challenge *digestAuth
+	basic := false
+	for _, v := range response.Header.Values("WWW-Authenticate") {
+		if c := parseDigestChallenge(v); c != nil && (challenge == nil || digestStrength(c.algorithm) > digestStrength(challenge.algorithm)) {
+			challenge = c
+		}
+		basic = basic || isBasicChallenge(v)
+	}
+
+	sum.Lock()
+	defer sum.Unlock()
+
+	if challenge == nil {
+		//asked again after sending them means the credentials are wrong
+		if !basic || sum.auth.basic || sum.auth.digest != nil {
+			return false
+		}
+		sum.debug.Printf("Server asked for basic authentication")
+		sum.auth.basic = true
+		return true
+	}
+
+	//the same challenge again means the credentials are wrong
+	if sum.auth.digest != nil && sum.auth.digest.nonce == challenge.nonce {
+		return false
+	}
+
+	sum.debug.Printf("Server asked for digest authentication, algorithm : %v", challenge.algorithm)
+	sum.auth.digest = challenge
+
+	return true
+}
+
+//checkRedirect drops the credentials when the server redirects to another host
+func (sum *Downloader) checkRedirect(req *http.Request, via []*http.Request) error {
+
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if req.URL.Host != via[0].URL.Host && req.Header.Get("Authorization") != "" {
+		sum.debug.Printf("Redirected to %v, not sending the credentials", req.URL.Host)
+		req.Header.Del("Authorization")
+	}
+
+	return nil
+}
+
+//isBasicChallenge tells if the WWW-Authenticate header asks for basic authentication
+func isBasicChallenge(h string) bool {
+	scheme := strings.SplitN(strings.TrimSpace(h), " ", 2)[0]
+	return strings.EqualFold(scheme, "Basic")
+}
+
+//parseDigestChallenge parses the WWW-Authenticate header, nil is returned if it is not a supported digest challenge
+func parseDigestChallenge(h string) *digestAuth {
+
+	kv := strings.SplitN(strings.TrimSpace(h), " ", 2)
+	if len(kv) != 2 || !strings.EqualFold(kv[0], "Digest") {
+		return nil
+	}
+
+	params := parseAuthParams(kv[1])
+
+	d := &digestAuth{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: strings.ToUpper(params["algorithm"]),
+		userhash:  strings.EqualFold(params["userhash"], "true"),
+	}
+
+	if d.algorithm == "" {
+		d.algorithm = "MD5"
+	}
+
+	if d.nonce == "" || digestStrength(d.algorithm) == 0 {
+		return nil
+	}
+
+	//auth-int needs the body, which we do not have for HEAD and GET requests so only auth is supported
+	if params["qop"] != "" {
+		for _, q := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(q) == "auth" {
+				d.qop = "auth"
+			}
+		}
+		if d.qop == "" {
+			return nil
+		}
+	}
+
+	return d
+}
+
+//digestStrength returns the preference of the algorithm, 0 if it is not supported
+func digestStrength(algo string) int {
+	switch strings.TrimSuffix(algo, "-SESS") {
+	case "SHA-512-256":
+		return 3
+	case "SHA-256":
+		return 2
+	case "MD5":
+		return 1
+	}
+	return 0
+}
+
+//authorization returns the Authorization header for the request
+func (d *digestAuth) authorization(username, password, method, uri string) (string, error) {
+
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		return "", err
+	}
+
+	return d.header(username, password, method, uri, hex.EncodeToString(cnonce)), nil
+}
+
+//header returns the Authorization header with the client nonce
+func (d *digestAuth) header(username, password, method, uri, cn string) string {
+
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(d.algorithm, "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	case "SHA-512-256":
+		newHash = sha512.New512_256
+	}
+
+	h := func(s string) string {
+		hasher := newHash()
+		hasher.Write([]byte(s))
+		return hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	d.Lock()
+	d.nc++
+	nc := fmt.Sprintf("%08x", d.nc)
+	d.Unlock()
+
+	ha1 := h(username + ":" + d.realm + ":" + password)
+	if strings.HasSuffix(d.algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + d.nonce + ":" + cn)
+	}
+	ha2 := h(method + ":" + uri)
+
+	response := h(ha1 + ":" + d.nonce + ":" + ha2)
+	if d.qop != "" {
+		response = h(ha1 + ":" + d.nonce + ":" + nc + ":" + cn + ":" + d.qop + ":" + ha2)
+	}
+
+	if d.userhash {
+		username = h(username + ":" + d.realm)
+	}
+
+	fields := []string{
+		fmt.Sprintf("username=%q", username),
+		fmt.Sprintf("realm=%q", d.realm),
+		fmt.Sprintf("uri=%q", uri),
+		"algorithm=" + d.algorithm,
+		fmt.Sprintf("nonce=%q", d.nonce),
+		fmt.Sprintf("response=%q", response),
+	}
+
+	if d.qop != "" {
+		fields = append(fields, "qop="+d.qop, "nc="+nc, fmt.Sprintf("cnonce=%q", cn))
+	}
+
+	if d.opaque != "" {
+		fields = append(fields, fmt.Sprintf("opaque=%q", d.opaque))
+	}
+
+	if d.userhash {
+		fields = append(fields, "userhash=true")
+	}
+
+	return "Digest " + strings.Join(fields, ", ")
+}
+
+//parseAuthParams parses comma separated name=value pairs where the value can be a quoted string containing commas
+func parseAuthParams(s string) map[string]string {
+
+	params := make(map[string]string)
+
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, " ,") {
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " ")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, "\"") {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:end]))
+			s = s[end:]
+		}
+
+		params[name] = value.String()
+	}
+
+	return params
+}
+
+//defaultNetrc returns the path of the netrc file in the home directory
+func defaultNetrc() string {
+
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".netrc")
+}
+
+//netrcLookup returns the login and password for the host from the netrc file, empty if the file or the host is not there
+func netrcLookup(path, host string) (string, string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("error while opening netrc file : %v", err)
+	}
+	defer f.Close()
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var (
+		login, password       string
+		defLogin, defPassword string
+		matched, inDefault    bool
+		hasDefault, macro     bool
+		tokens                []string
+		scanner               = bufio.NewScanner(f)
+	)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		//macro definitions end with an empty line
+		if macro {
+			macro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i, t := range fields {
+			if t == "macdef" {
+				macro = true
+				fields = fields[:i]
+				break
+			}
+		}
+		tokens = append(tokens, fields...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("error while reading netrc file : %v", err)
+	}
+
+tokens:
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine", "default":
+			//the first matching machine is used
+			if matched {
+				break tokens
+			}
+			inDefault = tokens[i] == "default"
+			if !inDefault && i+1 < len(tokens) {
+				i++
+				matched = strings.EqualFold(tokens[i], host)
+			}
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				break tokens
+			}
+			i++
+			switch {
+			case matched && tokens[i-1] == "login":
+				login = tokens[i]
+			case matched && tokens[i-1] == "password":
+				password = tokens[i]
+			case inDefault && tokens[i-1] == "login":
+				defLogin, hasDefault = tokens[i], true
+			case inDefault && tokens[i-1] == "password":
+				defPassword, hasDefault = tokens[i], true
+			}
+		}
+	}
+
+	if matched {
+		return login, password, nil
+	}
+
+	if hasDefault {
+		return defLogin, defPassword, nil
+	}
+
+	return "", "", nil
+}
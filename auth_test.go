@@ -0,0 +1,233 @@
+package summon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseDigestChallenge(t *testing.T) {
+
+	tests := map[string]struct {
+		header string
+		want   *digestAuth
+	}{
+		"rfc 7616": {
+			header: `Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+			want:   &digestAuth{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque: "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", algorithm: "SHA-256", qop: "auth"},
+		},
+		"default md5 and quoted comma": {
+			header: `digest realm="a, \"b\"", nonce=abc, userhash=true`,
+			want:   &digestAuth{realm: `a, "b"`, nonce: "abc", algorithm: "MD5", userhash: true},
+		},
+		"sess":          {header: `Digest nonce="n", algorithm=md5-sess`, want: &digestAuth{nonce: "n", algorithm: "MD5-SESS"}},
+		"basic":         {header: `Basic realm="x"`},
+		"no nonce":      {header: `Digest realm="x"`},
+		"unknown algo":  {header: `Digest nonce="n", algorithm=SHA-1`},
+		"only auth-int": {header: `Digest nonce="n", qop="auth-int"`},
+		"no parameters": {header: `Digest`},
+		"empty":         {header: ``},
+	}
+
+	for name, tt := range tests {
+
+		got := parseDigestChallenge(tt.header)
+
+		if (got == nil) != (tt.want == nil) {
+			t.Errorf("%s : parseDigestChallenge() = %+v, want %+v", name, got, tt.want)
+			continue
+		}
+
+		if got != nil && (got.realm != tt.want.realm || got.nonce != tt.want.nonce || got.opaque != tt.want.opaque ||
+			got.algorithm != tt.want.algorithm || got.qop != tt.want.qop || got.userhash != tt.want.userhash) {
+			t.Errorf("%s : parseDigestChallenge() = %+v, want %+v", name, got, tt.want)
+		}
+	}
+}
+
+//TestDigestAuthorization uses the examples of RFC 7616 section 3.9, the client nonce is fixed
+func TestDigestAuthorization(t *testing.T) {
+
+	tests := map[string]struct {
+		d                  *digestAuth
+		username, password string
+		uri, cnonce        string
+		want               []string
+	}{
+		"md5": {
+			d:        &digestAuth{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque: "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", algorithm: "MD5", qop: "auth"},
+			username: "Mufasa",
+			password: "Circle of Life",
+			uri:      "/dir/index.html",
+			cnonce:   "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want:     []string{`response="8ca523f5e9506fed4657c9700eebdbec"`, `username="Mufasa"`, "nc=00000001", `opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`},
+		},
+		"sha-256": {
+			d:        &digestAuth{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque: "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", algorithm: "SHA-256", qop: "auth"},
+			username: "Mufasa",
+			password: "Circle of Life",
+			uri:      "/dir/index.html",
+			cnonce:   "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want:     []string{`response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"`, "algorithm=SHA-256"},
+		},
+		//the hashes printed in the RFC for this example do not match its inputs, these are computed from them
+		"sha-512-256 userhash": {
+			d:        &digestAuth{realm: "api@example.org", nonce: "5TsQWLVdgBdmrQ0XsxbDODV+57QdFR34I9HAbC/RVvkK", opaque: "HRPCssKJSGjCrkzDg8OhwpzCiGPChXYjwrI2QmXDnsOS", algorithm: "SHA-512-256", qop: "auth", userhash: true},
+			username: "Jäsøn Doe",
+			password: "Secret, or not?",
+			uri:      "/doe.json",
+			cnonce:   "NTg6RKcb9boFIAS3KrFK9BGeh+iDa/sm6jUMp2wds69v",
+			want:     []string{`response="3798d4131c277846293534c3edc11bd8a5e4cdcbff78b05db9d95eeb1cec68a5"`, `username="793263caabb707a56211940d90411ea4a575adeccb7e360aeb624ed06ece9b0b"`, "userhash=true"},
+		},
+	}
+
+	for name, tt := range tests {
+
+		got := tt.d.header(tt.username, tt.password, "GET", tt.uri, tt.cnonce)
+
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s : header is %v, want %v in it", name, got, w)
+			}
+		}
+	}
+}
+
+func TestNetrcLookup(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "netrc")
+
+	netrc := `machine example.com login alice password secret1
+machine other.com
+  login bob
+  password secret2
+macdef init
+  machine evil.com login mallory password nope
+
+default login anon password guest
+`
+	if err := os.WriteFile(path, []byte(netrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host, login, password string
+	}{
+		{host: "example.com", login: "alice", password: "secret1"},
+		{host: "EXAMPLE.com:8443", login: "alice", password: "secret1"},
+		{host: "other.com", login: "bob", password: "secret2"},
+		{host: "evil.com", login: "anon", password: "guest"},
+		{host: "unknown.com", login: "anon", password: "guest"},
+	}
+
+	for _, tt := range tests {
+		login, password, err := netrcLookup(path, tt.host)
+		if err != nil || login != tt.login || password != tt.password {
+			t.Errorf("netrcLookup(%v) = %v, %v, %v, want %v, %v", tt.host, login, password, err, tt.login, tt.password)
+		}
+	}
+
+	if login, _, err := netrcLookup(filepath.Join(t.TempDir(), "missing"), "example.com"); err != nil || login != "" {
+		t.Errorf("netrcLookup() of missing file = %v, %v", login, err)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+
+	sum := &Downloader{debug: DevLogger{}}
+
+	newReq := func(u string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, u, nil)
+		r.Header.Set("Authorization", "Basic eDp5")
+		return r
+	}
+
+	first := newReq("http://example.com/a")
+
+	same := newReq("http://example.com/b")
+	if err := sum.checkRedirect(same, []*http.Request{first}); err != nil || same.Header.Get("Authorization") == "" {
+		t.Errorf("redirect to the same host dropped the credentials, err : %v", err)
+	}
+
+	other := newReq("http://cdn.example.com/a")
+	if err := sum.checkRedirect(other, []*http.Request{first, same}); err != nil || other.Header.Get("Authorization") != "" {
+		t.Errorf("redirect to another host kept the credentials, err : %v", err)
+	}
+
+	port := newReq("http://example.com:8080/a")
+	if err := sum.checkRedirect(port, []*http.Request{first}); err != nil || port.Header.Get("Authorization") != "" {
+		t.Errorf("redirect to another port kept the credentials, err : %v", err)
+	}
+
+	via := make([]*http.Request, 10)
+	for i := range via {
+		via[i] = first
+	}
+	if err := sum.checkRedirect(newReq("http://example.com/c"), via); err == nil {
+		t.Error("11th redirect is followed")
+	}
+}
+
+//TestAuthChallenge checks that the password is sent only after the server asks for it, with the scheme it asks for
+func TestAuthChallenge(t *testing.T) {
+
+	size := MiB
+
+	for _, scheme := range []string{"Basic", "Digest"} {
+		t.Run(scheme, func(t *testing.T) {
+
+			var early, wrong int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth := r.Header.Get("Authorization")
+				if auth == "" {
+					if scheme == "Digest" {
+						w.Header().Add("WWW-Authenticate", `Digest realm="test", nonce="abc", qop="auth", algorithm=SHA-256`)
+					}
+					w.Header().Add("WWW-Authenticate", `Basic realm="test"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if !strings.HasPrefix(auth, scheme+" ") {
+					atomic.AddInt32(&wrong, 1)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+			}))
+			defer srv.Close()
+
+			//a server which asks for nothing never gets the password
+			plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "" {
+					atomic.AddInt32(&early, 1)
+				}
+				http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+			}))
+			defer plain.Close()
+
+			for _, u := range []string{srv.URL, plain.URL} {
+				out := filepath.Join(t.TempDir(), "file.bin")
+
+				sum, err := New(u+"/file.bin", WithOutput(out), WithConnections(2), WithCredentials("user", "pass"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := sum.Run(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if early != 0 || wrong != 0 {
+				t.Fatalf("credentials were sent %d times before they were asked and %d times with the wrong scheme", early, wrong)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package summon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_PARALLEL_FILES = 3
+
+//BatchEntry is a single file of a batch download
+type BatchEntry struct {
+	URL     string
+	Line    int      //line of the input file where the entry starts
+	Options []Option //options for this file only, applied after the common options
+}
+
+//BatchResult is the outcome of a single file of a batch download
+type BatchResult struct {
+	URL      string //url of the entry without the credentials so that it can be logged
+	Path     string //absolute path of the downloaded file
+	Size     int64
+	Duration time.Duration
+	Err      error
+}
+
+//ParseInputFile parses a list of urls in the aria2 input file format. Every url is on its own line and can be
+//followed by indented name=value options for that url. Supported options are out, dir, checksum, header and connections (or split).
+//Empty lines and lines starting with # are ignored.
+//
+//	https://example.com/a.iso
+//	  out=b.iso
+//	  checksum=sha256:e3b0c442...
+//	  header=Authorization: Bearer abc
+//	  connections=8
+func ParseInputFile(r io.Reader) ([]BatchEntry, error) {
+
+	var entries []BatchEntry
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		//url lines start without any space
+		if trimmed == line {
+			//aria2 allows mirrors separated by tab, we only use the first one
+			u := strings.Fields(trimmed)[0]
+			if _, err := validate(u); err != nil {
+				return nil, fmt.Errorf("line %d : %v", lineNo, err)
+			}
+			entries = append(entries, BatchEntry{URL: u, Line: lineNo})
+			continue
+		}
+
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("line %d : option before any url", lineNo)
+		}
+
+		opt, err := parseEntryOption(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d : %v", lineNo, err)
+		}
+
+		last := &entries[len(entries)-1]
+		last.Options = append(last.Options, opt)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading input file : %v", err)
+	}
+
+	return entries, nil
+}
+
+//parseEntryOption converts a single name=value line of the input file to an option
+func parseEntryOption(s string) (Option, error) {
+
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("option should be in name=value format, got : %v", s)
+	}
+
+	name, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+
+	switch name {
+	case "out":
+		return WithOutput(value), nil
+	case "dir":
+		return WithOutputDir(value), nil
+	case "checksum":
+		//aria2 uses sha-256=hex, we use sha256:hex
+		if !strings.Contains(value, ":") {
+			value = strings.Replace(strings.Replace(value, "-", "", 1), "=", ":", 1)
+		}
+		if _, err := parseChecksum(value); err != nil {
+			return nil, err
+		}
+		return WithChecksum(value), nil
+	case "header":
+		if _, _, err := parseHeader(value); err != nil {
+			return nil, err
+		}
+		return WithRawHeader(value), nil
+	case "connections", "split":
+		c, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connections : %v", value)
+		}
+		return WithConnections(c), nil
+	}
+
+	return nil, fmt.Errorf("unsupported option : %v", name)
+}
+
+//RunBatch downloads the entries with at most parallel files at once, common options are applied to every entry before its own options.
+//It returns once all the entries are done, the results are in the order of the entries
+func RunBatch(ctx context.Context, entries []BatchEntry, parallel int, common ...Option) []BatchResult {
+
+	if parallel <= 0 {
+		parallel = DEFAULT_PARALLEL_FILES
+	}
+
+	results := make([]BatchResult, len(entries))
+	queue := make(chan int)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < parallel && i < len(entries); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				results[index] = runEntry(ctx, entries[index], common)
+			}
+		}()
+	}
+
+	for index := range entries {
+		//entries which are not started yet are marked as stopped
+		if ctx.Err() != nil {
+			results[index] = BatchResult{URL: urlWithoutUser(entries[index].URL), Err: ErrGracefulShutdown}
+			continue
+		}
+		queue <- index
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+func runEntry(ctx context.Context, entry BatchEntry, common []Option) BatchResult {
+
+	startTime := time.Now()
+	result := BatchResult{URL: urlWithoutUser(entry.URL)}
+
+	opts := append(append([]Option{}, common...), entry.Options...)
+
+	sum, err := New(entry.URL, opts...)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Err = sum.Run(ctx)
+	result.Path = sum.OutputPath()
+	result.Size = sum.Size()
+	result.Duration = time.Since(startTime)
+
+	return result
+}
@@ -0,0 +1,238 @@
+package summon
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+//ErrChecksumMismatch is returned by Run when the downloaded file does not match the expected checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+//hashes are the supported checksum algorithms
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		//error is only returned for keys longer than 64 bytes
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+}
+
+//checksum hashes the file in order while it is being written, bytes written out of order are hashed later
+type checksum struct {
+	algo     string
+	expected []byte
+	h        hash.Hash
+	offset   int64  //bytes hashed till now
+	source   string //header the expected value came from, empty when it was passed by the user
+	sync.Mutex
+}
+
+//parseChecksum parses the checksum in algo:hex format, for example sha256:e3b0c442...
+func parseChecksum(spec string) (*checksum, error) {
+
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("checksum should be in algo:hex format, got : %v", spec)
+	}
+
+	algo := strings.ToLower(parts[0])
+
+	expected, err := hex.DecodeString(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("checksum is not valid hex : %v", err)
+	}
+
+	return newChecksum(algo, expected, "")
+}
+
+//newChecksum returns the checksum for the algorithm, source tells where the expected value came from and is empty when it was passed by the user
+func newChecksum(algo string, expected []byte, source string) (*checksum, error) {
+
+	newHash, ok := hashes[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm : %v, supported are %v", algo, supportedHashes())
+	}
+
+	h := newHash()
+	if len(expected) != h.Size() {
+		return nil, fmt.Errorf("%v checksum should be %d bytes, got %d", algo, h.Size(), len(expected))
+	}
+
+	return &checksum{algo: algo, expected: expected, h: h, source: source}, nil
+}
+
+func supportedHashes() string {
+
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+//writeAt hashes the bytes written at the offset if they continue from what is hashed till now, it is safe to call on nil
+func (c *checksum) writeAt(b []byte, off int64) {
+
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	end := off + int64(len(b))
+	if off > c.offset || end <= c.offset {
+		return
+	}
+
+	c.h.Write(b[c.offset-off:])
+	c.offset = end
+}
+
+//reset discards what is hashed till now, used when the download starts again from the first byte
+func (c *checksum) reset() {
+
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.h.Reset()
+	c.offset = 0
+}
+
+//state returns the state of the hash to save in the meta file, nil if nothing is hashed or the hash cannot be saved
+func (c *checksum) state() *metaChecksum {
+
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	m, ok := c.h.(encoding.BinaryMarshaler)
+	if !ok || c.offset == 0 {
+		return nil
+	}
+
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+
+	return &metaChecksum{Algo: c.algo, Offset: c.offset, State: state}
+}
+
+//restore continues the hash from the state saved in the meta file, downloaded is the number of bytes from the start of the file which are downloaded
+func (c *checksum) restore(mc *metaChecksum, downloaded int64) bool {
+
+	if c == nil || mc == nil || mc.Algo != c.algo || mc.Offset > downloaded {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	u, ok := c.h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return false
+	}
+
+	if err := u.UnmarshalBinary(mc.State); err != nil {
+		c.h.Reset()
+		return false
+	}
+
+	c.offset = mc.Offset
+
+	return true
+}
+
+//verify hashes the part of the file which was not hashed while writing and compares it with the expected checksum.
+//The file should be exactly size bytes, bytes after it would not be covered by the checksum
+func (c *checksum) verify(f io.ReaderAt, size int64) error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.offset < size {
+		n, err := io.Copy(c.h, io.NewSectionReader(f, c.offset, size-c.offset))
+		if err != nil {
+			return fmt.Errorf("error while reading file for checksum : %v", err)
+		}
+		c.offset += n
+	}
+
+	if c.offset != size {
+		return fmt.Errorf("%w : file has %d bytes, expected %d", ErrChecksumMismatch, c.offset, size)
+	}
+
+	if n, _ := f.ReadAt(make([]byte, 1), size); n != 0 {
+		return fmt.Errorf("%w : file has more than the expected %d bytes", ErrChecksumMismatch, size)
+	}
+
+	if got := c.h.Sum(nil); !bytes.Equal(got, c.expected) {
+		return fmt.Errorf("%w : %v expected %x, got %x", ErrChecksumMismatch, c.algo, c.expected, got)
+	}
+
+	return nil
+}
+
+//offsetHasher feeds the bytes copied to the output file to the checksum
+type offsetHasher struct {
+	c   *checksum
+	off int64
+}
+
+func (oh *offsetHasher) Write(b []byte) (int, error) {
+	oh.c.writeAt(b, oh.off)
+	oh.off += int64(len(b))
+	return len(b), nil
+}
+
+//verifyChecksum verifies the output file if a checksum was passed
+func (sum *Downloader) verifyChecksum(f *os.File, size int64) error {
+
+	if sum.checksum == nil {
+		return nil
+	}
+
+	defer sum.startTimer("Time took for verifying checksum is")()
+
+	if err := sum.checksum.verify(f, size); err != nil {
+		return err
+	}
+
+	if sum.checksum.source != "" {
+		sum.log.Printf("Checksum verified : %v from %v header", sum.checksum.algo, sum.checksum.source)
+		return nil
+	}
+
+	sum.log.Printf("Checksum verified : %v", sum.checksum.algo)
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package summon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+
+	sha := sha256.Sum256(nil)
+
+	tests := map[string]struct {
+		spec string
+		algo string
+		ok   bool
+	}{
+		"sha256":          {spec: fmt.Sprintf("sha256:%x", sha), algo: "sha256", ok: true},
+		"upper case":      {spec: fmt.Sprintf("SHA256:%X", sha), algo: "sha256", ok: true},
+		"spaces":          {spec: fmt.Sprintf("sha256: %x ", sha), algo: "sha256", ok: true},
+		"md5":             {spec: "md5:d41d8cd98f00b204e9800998ecf8427e", algo: "md5", ok: true},
+		"no algo":         {spec: fmt.Sprintf("%x", sha)},
+		"unknown algo":    {spec: fmt.Sprintf("sha3:%x", sha)},
+		"not hex":         {spec: "md5:d41d8cd98f00b204e9800998ecf8427z"},
+		"wrong length":    {spec: "sha256:d41d8cd98f00b204e9800998ecf8427e"},
+		"empty checksum":  {spec: "sha256:"},
+		"blake2b":         {spec: "blake2b:" + string(bytes.Repeat([]byte("ab"), 64)), algo: "blake2b", ok: true},
+		"blake2b too big": {spec: "blake2b:" + string(bytes.Repeat([]byte("ab"), 65))},
+	}
+
+	for name, tt := range tests {
+
+		c, err := parseChecksum(tt.spec)
+
+		if (err == nil) != tt.ok {
+			t.Errorf("%s : parseChecksum(%v) error = %v, want ok %v", name, tt.spec, err, tt.ok)
+			continue
+		}
+
+		if err == nil && c.algo != tt.algo {
+			t.Errorf("%s : parseChecksum(%v) algo = %v, want %v", name, tt.spec, c.algo, tt.algo)
+		}
+	}
+}
+
+func TestChecksumVerify(t *testing.T) {
+
+	data := bytes.Repeat([]byte("summon"), 1000)
+	size := int64(len(data))
+	sum := sha256.Sum256(data)
+
+	tests := map[string]struct {
+		file   []byte
+		hashed int64 //bytes hashed while writing
+		ok     bool
+	}{
+		"hashed while writing": {file: data, hashed: size, ok: true},
+		"hashed at the end":    {file: data, ok: true},
+		"half hashed":          {file: data, hashed: size / 2, ok: true},
+		"changed":              {file: append(append([]byte{}, data[:size-1]...), 'x')},
+		"short":                {file: data[:size-1]},
+		"extra bytes":          {file: append(append([]byte{}, data...), 'x'), hashed: size},
+		"stale bytes first":    {file: append([]byte("stale"), data...), hashed: size},
+	}
+
+	for name, tt := range tests {
+
+		c, err := newChecksum("sha256", sum[:], "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.writeAt(data[:tt.hashed], 0)
+
+		err = c.verify(bytes.NewReader(tt.file), size)
+
+		if tt.ok && err != nil {
+			t.Errorf("%s : verify() = %v", name, err)
+		}
+
+		if !tt.ok && !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%s : verify() = %v, want %v", name, err, ErrChecksumMismatch)
+		}
+	}
+}
+
+//TestChecksumMismatch checks that a corrupt download is deleted, or kept at the temp path with -keep-corrupt
+func TestChecksumMismatch(t *testing.T) {
+
+	size := 2*MiB + 7
+	srv := newRangeServer(t, size, true)
+
+	h := sha256.New()
+	io.Copy(h, &virtualFile{size: size})
+	good := fmt.Sprintf("sha256:%x", h.Sum(nil))
+	bad := fmt.Sprintf("sha256:%x", sha256.Sum256(nil))
+
+	for _, keep := range []bool{false, true} {
+
+		dir := t.TempDir()
+		out := filepath.Join(dir, "file.bin")
+		temp := filepath.Join(dir, ".file.bin")
+
+		sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(4), WithChecksum(bad), WithKeepCorrupt(keep))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sum.Run(context.Background()); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("keep %v : Run() = %v, want %v", keep, err, ErrChecksumMismatch)
+		}
+
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Fatalf("keep %v : corrupt file is at the output path, err : %v", keep, err)
+		}
+
+		if _, err := os.Stat(temp); os.IsNotExist(err) == keep {
+			t.Fatalf("keep %v : temp file exists %v", keep, err == nil)
+		}
+
+		if entries, _ := os.ReadDir(dir); keep && len(entries) != 1 {
+			t.Fatalf("keep %v : %d files are left, want only the temp file", keep, len(entries))
+		}
+
+		if !keep {
+			continue
+		}
+
+		f, err := os.Open(temp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi, _ := f.Stat(); fi.Size() != size {
+			t.Fatalf("kept file has %d bytes, want %d", fi.Size(), size)
+		}
+		checkRange(t, f, 0, size)
+		f.Close()
+	}
+
+	//the same download passes with the right checksum
+	out := filepath.Join(t.TempDir(), "file.bin")
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(4), WithChecksum(good))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
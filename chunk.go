@@ -0,0 +1,113 @@
+package summon
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+//chunkWriter is where a connection writes the bytes of its range
+type chunkWriter interface {
+	io.Writer
+	//reset discards everything written so far, used when the range has to be downloaded again from the start
+	reset() error
+}
+
+//partFile writes the range to its own part file which is combined later
+type partFile struct {
+	*os.File
+}
+
+func (pf partFile) reset() error {
+
+	if err := pf.Truncate(0); err != nil {
+		return err
+	}
+
+	//truncating does not move the offset, the next write would leave a hole of zeros before it
+	_, err := pf.Seek(0, io.SeekStart)
+
+	return err
+}
+
+//offsetWriter writes the range directly into the output file at its own offset
+type offsetWriter struct {
+	w    io.WriterAt
+	base int64 //start of the range
+	off  int64 //where the next write goes
+}
+
+func (ow *offsetWriter) Write(b []byte) (int, error) {
+	n, err := ow.w.WriteAt(b, ow.off)
+	ow.off += int64(n)
+	return n, err
+}
+
+func (ow *offsetWriter) reset() error {
+	ow.off = ow.base
+	return nil
+}
+
+//createChunkWriter creates the writer for a new range
+func (sum *Downloader) createChunkWriter(index, start, end int64) (chunkWriter, error) {
+
+	if sum.direct {
+		return &offsetWriter{w: sum.fileDetails.tempOutFile, base: start, off: start}, nil
+	}
+
+	//get temp file name
+	partFileName, err := sum.getTempFileName(index, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	//Create temp file
+	f, err := os.Create(partFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	//init temp files
+	sum.fileDetails.chunks[index] = f
+
+	return partFile{f}, nil
+}
+
+//openChunkWriter opens the writer for a range which was partially downloaded earlier
+func (sum *Downloader) openChunkWriter(index int64, r resume) (chunkWriter, error) {
+
+	if sum.direct {
+		return &offsetWriter{w: sum.fileDetails.tempOutFile, base: r.start, off: r.start + r.downloaded}, nil
+	}
+
+	f, err := os.OpenFile(r.tempFilePath, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	//bytes after the last checkpoint are downloaded again
+	if err := f.Truncate(r.downloaded); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	//Set the file handles so that combine can use them
+	sum.fileDetails.chunks[index] = f
+
+	return partFile{f}, nil
+}
+
+//preallocate reserves the space for the output file so that the connections can write at their offsets
+func preallocate(f *os.File, size int64) error {
+
+	if err := fallocate(f, size); err == nil {
+		return nil
+	}
+
+	//filesystem does not support allocating, create a sparse file instead
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("error while truncating file : %v", err)
+	}
+
+	return nil
+}
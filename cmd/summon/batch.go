@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/akshaykhairmode/summon"
+)
+
+//prefixLogger adds the file to every log line as files of a batch are downloaded at once
+type prefixLogger struct {
+	prefix string
+	l      summon.Logger
+}
+
+func (pl prefixLogger) Printf(format string, args ...interface{}) {
+	pl.l.Printf(pl.prefix+format, args...)
+}
+
+func (pl prefixLogger) Println(args ...interface{}) {
+	pl.l.Println(append([]interface{}{strings.TrimSpace(pl.prefix)}, args...)...)
+}
+
+//runBatch downloads all the urls of the input file and prints the summary, it exits with an error code if any of them failed
+func runBatch(args arguments) {
+
+	if flag.NArg() > 0 || args.outputFile != "" || args.checksum != "" {
+		log.Fatalf("ERROR : url, -o and -checksum cannot be used with -i, set them in the input file instead")
+	}
+
+	entries, err := readInputFile(args.inputFile)
+	if err != nil {
+		log.Fatalf("ERROR : %s", err)
+	}
+
+	if len(entries) == 0 {
+		log.Fatalf("ERROR : no urls found in %v", args.inputFile)
+	}
+
+	for i := range entries {
+		prefix := fmt.Sprintf("[%d/%d] ", i+1, len(entries))
+		entries[i].Options = append([]summon.Option{
+			summon.WithLogger(prefixLogger{prefix: prefix, l: summon.DevLogger{}}),
+			summon.WithDebugLogger(prefixLogger{prefix: prefix, l: args.logger()}),
+		}, entries[i].Options...)
+		log.Printf("%sQueued : %v", prefix, withoutUser(entries[i].URL))
+	}
+
+	//get the user kill signals
+	ctx := catchSignals()
+
+	results := summon.RunBatch(ctx, entries, args.parallel, args.commonOptions()...)
+
+	if code := printSummary(results); code != 0 {
+		os.Exit(code)
+	}
+}
+
+//withoutUser removes the credentials of the url so that they do not show up in the logs
+func withoutUser(u string) string {
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "invalid url"
+	}
+
+	parsed.User = nil
+
+	return parsed.String()
+}
+
+func readInputFile(path string) ([]summon.BatchEntry, error) {
+
+	var r io.Reader = os.Stdin
+
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error while opening input file : %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return summon.ParseInputFile(r)
+}
+
+//printSummary prints one line per file and the totals, it returns the exit code of the first failed file
+func printSummary(results []summon.BatchResult) int {
+
+	var ok, failed, stopped int
+	var size int64
+	code := 0
+
+	fmt.Println("\nSummary")
+
+	for i, r := range results {
+		switch {
+		case r.Err == nil:
+			ok++
+			size += r.Size
+			fmt.Printf("[%d] OK      %v -> %v (%v in %v)\n", i+1, r.URL, r.Path, summon.HumanSizeFromBytes(r.Size), r.Duration.Round(time.Millisecond))
+		case r.Err == summon.ErrGracefulShutdown:
+			stopped++
+			fmt.Printf("[%d] STOPPED %v\n", i+1, r.URL)
+		default:
+			failed++
+			if code == 0 {
+				code = exitCode(r.Err)
+			}
+			fmt.Printf("[%d] FAILED  %v : %v\n", i+1, r.URL, r.Err)
+		}
+	}
+
+	fmt.Printf("\n%d downloaded (%v), %d failed, %d stopped\n", ok, summon.HumanSizeFromBytes(size), failed, stopped)
+
+	return code
+}
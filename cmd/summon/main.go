@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/akshaykhairmode/summon"
+)
+
+const (
+	EXIT_CHECKSUM_MISMATCH = 3
+	EXIT_LOCKED            = 4
+)
+
+func init() {
+	log.SetOutput(os.Stdout)
+	flag.CommandLine.SetOutput(os.Stdout)
+}
+
+func main() {
+
+	printWarnings()
+
+	defer recoverMain()
+
+	if runSubcommand(os.Args) {
+		return
+	}
+
+	args := arguments{}
+
+	parseFlags(&args)
+
+	if args.help {
+		flag.PrintDefaults()
+		fmt.Println("\nExample Usage - $GOBIN/summon -c 5 http://www.africau.edu/images/default/sample.pdf")
+		os.Exit(0)
+	}
+
+	if err := args.setResumePolicy(); err != nil {
+		log.Fatalf("ERROR : %s", err)
+	}
+
+	if err := args.setLockMode(); err != nil {
+		log.Fatalf("ERROR : %s", err)
+	}
+
+	if err := args.setRateLimit(); err != nil {
+		log.Fatalf("ERROR : %s", err)
+	}
+
+	watchRateFile(args.rateFile, args.limiter)
+
+	startTime := time.Now()
+
+	if args.inputFile != "" {
+		runBatch(args)
+		args.logger().Printf("Time took : %v", time.Since(startTime))
+		return
+	}
+
+	sum, err := summon.New(flag.Arg(0), args.options()...)
+	if err != nil {
+		log.Fatalf("ERROR : %s", err)
+	}
+
+	//get the user kill signals
+	ctx := catchSignals()
+
+	if err := sum.Run(ctx); err != nil && err != summon.ErrGracefulShutdown {
+		log.Printf("ERROR : %s", err)
+		os.Exit(exitCode(err))
+	}
+
+	args.logger().Printf("Time took : %v", time.Since(startTime))
+
+}
+
+//catchSignals returns a context which is cancelled on the first stop signal so that the download can be resumed later,
+//the second signal exits immediately
+func catchSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGQUIT)
+	go func() {
+		s := <-sigc
+		log.Printf("Got stop signal : %v, stopping the download. Press Ctrl-C again to force exit", s)
+		cancel()
+		<-sigc
+		log.Println("Force exiting")
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+//confirmResume asks the user on the terminal if the incomplete download should be resumed, enter is same as yes
+func confirmResume(path string) (bool, error) {
+
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Print("Looks like previous download was incomplete for this file, do you want to resume ? [Y/n] ")
+
+		answer, err := reader.ReadString('\n')
+		if err != nil && answer == "" {
+			return false, fmt.Errorf("no answer, use -resume to choose without asking : %v", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+
+		fmt.Println("Please answer y or n")
+	}
+}
+
+//isTerminal tells if the file is a terminal, stdin is not one in scripts and CI.
+//The null device is also a character device so it is checked separately
+func isTerminal(f *os.File) bool {
+
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+
+	return err != nil || !os.SameFile(fi, null)
+}
+
+func printWarnings() {
+	if runtime.GOOS == "windows" {
+		log.Println("WARNING: It may not work as expected on windows")
+	}
+}
+
+//exitCode returns a distinct exit code for the errors scripts may want to handle
+func exitCode(err error) int {
+
+	if errors.Is(err, summon.ErrChecksumMismatch) {
+		return EXIT_CHECKSUM_MISMATCH
+	}
+
+	if errors.Is(err, summon.ErrLocked) {
+		return EXIT_LOCKED
+	}
+
+	return 1
+}
+
+func recoverMain() {
+	if err := recover(); err != nil {
+		log.Printf("Recovered Error : %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akshaykhairmode/summon"
+)
+
+//runSubcommand runs the subcommand if it is the first argument, false is returned when it is not a subcommand
+func runSubcommand(osArgs []string) bool {
+
+	if len(osArgs) < 2 {
+		return false
+	}
+
+	switch osArgs[1] {
+	case "status":
+		runStatus(osArgs[2:])
+	case "clean":
+		runClean(osArgs[2:])
+	default:
+		return false
+	}
+
+	return true
+}
+
+//runStatus lists the incomplete downloads found in the directories
+func runStatus(osArgs []string) {
+
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage : summon status [dir or output ...]\nlists the incomplete downloads in the directory trees, default is the current directory")
+		fs.PrintDefaults()
+	}
+	fs.Parse(osArgs)
+
+	partials, ok := findPartials(fs.Args())
+
+	printPartials(partials)
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+//printPartials prints a table of the incomplete downloads
+func printPartials(partials []summon.Partial) {
+
+	if len(partials) == 0 {
+		fmt.Println("No incomplete downloads found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OUTPUT\tSTATE\tDONE\tAGE\tDISK\tURL")
+
+	for _, p := range partials {
+		url := p.URL
+		if p.Err != nil {
+			url = "ERROR : " + p.Err.Error()
+		}
+		state := "stopped"
+		if p.Locked {
+			state = "downloading"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Output, state, partialDone(p), formatAge(time.Since(p.Updated)), summon.HumanSizeFromBytes(p.DiskUsage), url)
+	}
+
+	w.Flush()
+}
+
+//runClean deletes the files of the incomplete downloads found in the directories, the ones being downloaded are skipped
+func runClean(osArgs []string) {
+
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "only print what would be deleted")
+	olderThan := fs.Duration("older-than", 0, "delete only the downloads which were not updated for this long, for example 168h")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage : summon clean [-n] [-older-than duration] [dir or output ...]\ndeletes the incomplete downloads in the directory trees, default is the current directory")
+		fs.PrintDefaults()
+	}
+	fs.Parse(osArgs)
+
+	partials, ok := findPartials(fs.Args())
+
+	failed := !ok
+	var freed int64
+
+	for _, p := range partials {
+
+		if p.Locked {
+			log.Printf("Skipping %v, it is being downloaded", p.Output)
+			continue
+		}
+
+		if time.Since(p.Updated) < *olderThan {
+			continue
+		}
+
+		if *dryRun {
+			log.Printf("Would delete %v, %s in %d files", p.Output, summon.HumanSizeFromBytes(p.DiskUsage), len(p.Files))
+			freed += p.DiskUsage
+			continue
+		}
+
+		if err := p.Remove(); err != nil {
+			log.Printf("ERROR : deleting %v : %s", p.Output, err)
+			failed = true
+			continue
+		}
+
+		log.Printf("Deleted %v, %s in %d files", p.Output, summon.HumanSizeFromBytes(p.DiskUsage), len(p.Files))
+		freed += p.DiskUsage
+	}
+
+	if *dryRun {
+		log.Printf("Would free %s", summon.HumanSizeFromBytes(freed))
+	} else {
+		log.Printf("Freed %s", summon.HumanSizeFromBytes(freed))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+//findPartials finds the incomplete downloads in all the paths, the current directory when none are passed.
+//ok is false if any of the paths could not be read
+func findPartials(paths []string) (partials []summon.Partial, ok bool) {
+
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	partials, ok = []summon.Partial{}, true
+
+	skipped := func(path string, err error) {
+		log.Printf("Skipping %v : %s", path, err)
+	}
+
+	for _, path := range paths {
+		found, err := summon.FindPartials(path, skipped)
+		if err != nil {
+			log.Printf("ERROR : %s", err)
+			ok = false
+			continue
+		}
+		partials = append(partials, found...)
+	}
+
+	return partials, ok
+}
+
+//partialDone returns the percent downloaded, or the bytes when the size is not known
+func partialDone(p summon.Partial) string {
+
+	if pct := p.Percent(); pct >= 0 {
+		return fmt.Sprintf("%.1f%%", pct)
+	}
+
+	if p.Done >= 0 {
+		return summon.HumanSizeFromBytes(p.Done)
+	}
+
+	return "-"
+}
+
+//formatAge rounds the age so that it is easy to read, days are used for older downloads
+func formatAge(d time.Duration) string {
+
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akshaykhairmode/summon"
+)
+
+const DEFAULT_PROGRESS_SIZE = 30
+
+//terminalProgress prints the total progress and one progress bar per connection on the terminal
+type terminalProgress struct {
+	size     int       //width of the bar
+	conns    int64     //highest connection number seen, used to keep the number of printed lines same on every update
+	last     int64     //bytes downloaded at the last update, used for the speed
+	lastTime time.Time //time of the last update
+}
+
+func newTerminalProgress(size int) *terminalProgress {
+	return &terminalProgress{size: size}
+}
+
+func (tp *terminalProgress) Update(ps []summon.Progress) {
+
+	lines := tp.print(ps)
+
+	//Move cursor back
+	for i := 0; i < lines; i++ {
+		fmt.Print("\033[F")
+	}
+}
+
+func (tp *terminalProgress) Done(ps []summon.Progress) {
+	tp.print(ps)
+}
+
+//print prints the progress and returns the number of lines printed
+func (tp *terminalProgress) print(ps []summon.Progress) int {
+
+	var curr, total int64
+	unknown := false
+	byConn := make(map[int64]summon.Progress)
+
+	for _, p := range ps {
+		curr += p.Current
+		total += p.Total
+		unknown = unknown || p.Total < 0
+
+		if p.Connection > 0 {
+			byConn[p.Connection] = p
+		}
+
+		if p.Connection > tp.conns {
+			tp.conns = p.Connection
+		}
+	}
+
+	speed := tp.speed(curr)
+
+	//without the size only the bytes downloaded and the speed can be shown
+	if unknown {
+		fmt.Printf("Total         - %-12s %s/s\n", summon.HumanSizeFromBytes(curr), summon.HumanSizeFromBytes(speed))
+		return 1
+	}
+
+	fmt.Printf("Total         - %s\n", tp.bar(curr, total))
+
+	for c := int64(1); c <= tp.conns; c++ {
+		p, ok := byConn[c]
+		if !ok {
+			fmt.Printf("Connection %-2d - %-*s\n", c, tp.size+7, "idle")
+			continue
+		}
+		fmt.Printf("Connection %-2d - %s segment %-4d\n", c, tp.bar(p.Current, p.Total), p.Index+1)
+	}
+
+	return int(tp.conns) + 1
+}
+
+//speed returns the bytes downloaded per second since the last update
+func (tp *terminalProgress) speed(curr int64) int64 {
+
+	now := time.Now()
+	defer func() {
+		tp.last, tp.lastTime = curr, now
+	}()
+
+	elapsed := now.Sub(tp.lastTime).Seconds()
+	if tp.lastTime.IsZero() || elapsed <= 0 {
+		return 0
+	}
+
+	return int64(float64(curr-tp.last) / elapsed)
+}
+
+func (tp *terminalProgress) bar(curr, total int64) string {
+
+	s := strings.Builder{}
+
+	percent := float64(100)
+	if total > 0 {
+		percent = math.Round((float64(curr) / float64(total)) * 100)
+	}
+
+	n := int((percent / 100) * float64(tp.size))
+
+	s.WriteString("[")
+	for i := 0; i < tp.size; i++ {
+		if i < n {
+			s.WriteString(">")
+		} else {
+			s.WriteString(" ")
+		}
+	}
+	s.WriteString("]")
+	s.WriteString(fmt.Sprintf(" %3v%%", percent))
+
+	return s.String()
+}
+
+func getProgressSize(logger summon.Logger) int {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+
+	if err != nil {
+		logger.Printf("error occured while size command : %v", err)
+		return DEFAULT_PROGRESS_SIZE
+	}
+
+	data := strings.Split(strings.TrimRight(string(out), "\n"), " ")
+	if len(data) < 2 {
+		return DEFAULT_PROGRESS_SIZE
+	}
+
+	i, err := strconv.Atoi(data[1])
+	if err != nil {
+		logger.Printf("error occured while converting str to int : %v", err)
+		return DEFAULT_PROGRESS_SIZE
+	}
+
+	//35 percent of the available terminal size
+	return int(math.Round(0.35 * float64(i)))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/akshaykhairmode/summon"
+)
+
+//readRateFile reads the rate limit from the file, an empty file means unlimited
+func readRateFile(path string) (int64, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("error while reading rate file : %v", err)
+	}
+
+	return parseOptionalRate(strings.TrimSpace(string(data)))
+}
+
+//watchRateFile reads the rate file again on every rateSignals signal and changes the limit of the running downloads
+func watchRateFile(path string, limiter *summon.RateLimiter) {
+
+	if path == "" || len(rateSignals) == 0 {
+		return
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, rateSignals...)
+
+	go func() {
+		for range sigc {
+			rate, err := readRateFile(path)
+			if err != nil {
+				log.Printf("Could not change the rate limit : %v", err)
+				continue
+			}
+
+			limiter.SetRate(rate)
+
+			if rate == 0 {
+				log.Printf("Rate limit removed")
+				continue
+			}
+
+			log.Printf("Rate limit changed to %v/s", summon.HumanSizeFromBytes(rate))
+		}
+	}()
+}
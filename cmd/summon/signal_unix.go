@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+//rateSignals make summon read the rate file again
+var rateSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+//rateSignals is empty as windows does not have user signals, the rate file is only read at the start
+var rateSignals = []os.Signal{}
@@ -0,0 +1,160 @@
+package summon
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+//digestAlgos maps the algorithm names used in the Digest and Repr-Digest headers to ours, strongest first
+var digestAlgos = []struct {
+	name string
+	algo string
+}{
+	{"sha-512", "sha512"},
+	{"sha-256", "sha256"},
+	{"sha", "sha1"},
+	{"md5", "md5"},
+}
+
+//setServerChecksum uses the checksum sent by the server to verify the file when the user did not pass one
+func (sum *Downloader) setServerChecksum(headers http.Header) {
+
+	if sum.checksum != nil || !sum.serverChecksum {
+		return
+	}
+
+	c := serverChecksum(headers)
+	if c == nil {
+		sum.debug.Printf("No usable checksum found in the headers")
+		return
+	}
+
+	sum.log.Printf("Using %v from %v header to verify the file", c.algo, c.source)
+	sum.checksum = c
+}
+
+//serverChecksum returns the checksum from the Repr-Digest, Digest, x-goog-hash, Content-MD5 or S3 ETag headers in that order, nil if none of them is usable
+func serverChecksum(headers http.Header) *checksum {
+
+	//checksums are of the encoded content which we do not get back
+	if ce := headers.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		return nil
+	}
+
+	//Repr-Digest: sha-256=:base64:, RFC 9530
+	if c := digestChecksum(headers, "Repr-Digest"); c != nil {
+		return c
+	}
+
+	//Digest: SHA-256=base64, RFC 3230
+	if c := digestChecksum(headers, "Digest"); c != nil {
+		return c
+	}
+
+	//x-goog-hash: crc32c=base64, md5=base64
+	if v, ok := headerParams(headers, "X-Goog-Hash")["md5"]; ok {
+		if c := base64Checksum("md5", v, "x-goog-hash"); c != nil {
+			return c
+		}
+	}
+
+	if v := headers.Get("Content-MD5"); v != "" {
+		if c := base64Checksum("md5", v, "Content-MD5"); c != nil {
+			return c
+		}
+	}
+
+	return etagChecksum(headers)
+}
+
+//digestChecksum returns the strongest usable checksum in the Digest or Repr-Digest header
+func digestChecksum(headers http.Header, name string) *checksum {
+
+	params := headerParams(headers, name)
+
+	for _, da := range digestAlgos {
+		v, ok := params[da.name]
+		if !ok {
+			continue
+		}
+
+		//structured field byte sequences are wrapped in colons
+		if c := base64Checksum(da.algo, strings.Trim(v, ":"), name); c != nil {
+			return c
+		}
+	}
+
+	return nil
+}
+
+//etagChecksum returns the md5 from the ETag of single part S3 objects, other servers do not use md5 for the ETag
+func etagChecksum(headers http.Header) *checksum {
+
+	if headers.Get("X-Amz-Request-Id") == "" && headers.Get("Server") != "AmazonS3" {
+		return nil
+	}
+
+	//objects encrypted with KMS or a key of the customer do not have the md5 in the ETag, even single part ones
+	if sse := strings.ToLower(headers.Get("X-Amz-Server-Side-Encryption")); strings.HasPrefix(sse, "aws:kms") {
+		return nil
+	}
+
+	for name := range headers {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-server-side-encryption-customer-") {
+			return nil
+		}
+	}
+
+	//weak etags and multipart etags like "abc-2" are not md5 of the content
+	etag := headers.Get("ETag")
+	if strings.HasPrefix(etag, "W/") {
+		return nil
+	}
+
+	b, err := hex.DecodeString(strings.Trim(etag, `"`))
+	if err != nil {
+		return nil
+	}
+
+	c, err := newChecksum("md5", b, "ETag")
+	if err != nil {
+		return nil
+	}
+
+	return c
+}
+
+func base64Checksum(algo, v, source string) *checksum {
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return nil
+	}
+
+	c, err := newChecksum(algo, b, source)
+	if err != nil {
+		return nil
+	}
+
+	return c
+}
+
+//headerParams parses comma separated name=value pairs from all the values of the header, names are lower cased
+func headerParams(headers http.Header, name string) map[string]string {
+
+	params := make(map[string]string)
+
+	for _, v := range headers.Values(name) {
+		for _, p := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	return params
+}
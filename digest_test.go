@@ -0,0 +1,39 @@
+package summon
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestETagChecksum(t *testing.T) {
+
+	const md5 = `"9e107d9d372bb6826bd81d3542a419d6"`
+
+	tests := map[string]struct {
+		headers map[string]string
+		want    bool
+	}{
+		"s3":               {headers: map[string]string{"Server": "AmazonS3", "ETag": md5}, want: true},
+		"s3 sse":           {headers: map[string]string{"Server": "AmazonS3", "ETag": md5, "X-Amz-Server-Side-Encryption": "AES256"}, want: true},
+		"not s3":           {headers: map[string]string{"ETag": md5}},
+		"weak":             {headers: map[string]string{"Server": "AmazonS3", "ETag": "W/" + md5}},
+		"multipart":        {headers: map[string]string{"Server": "AmazonS3", "ETag": `"9e107d9d372bb6826bd81d3542a419d6-2"`}},
+		"kms":              {headers: map[string]string{"Server": "AmazonS3", "ETag": md5, "X-Amz-Server-Side-Encryption": "aws:kms"}},
+		"kms dsse":         {headers: map[string]string{"Server": "AmazonS3", "ETag": md5, "X-Amz-Server-Side-Encryption": "aws:kms:dsse"}},
+		"customer key":     {headers: map[string]string{"Server": "AmazonS3", "ETag": md5, "X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256"}},
+		"customer key md5": {headers: map[string]string{"X-Amz-Request-Id": "1", "ETag": md5, "X-Amz-Server-Side-Encryption-Customer-Key-Md5": "abc"}},
+		"request id":       {headers: map[string]string{"X-Amz-Request-Id": "1", "ETag": md5}, want: true},
+	}
+
+	for name, tt := range tests {
+
+		h := http.Header{}
+		for k, v := range tt.headers {
+			h.Set(k, v)
+		}
+
+		if got := etagChecksum(h) != nil; got != tt.want {
+			t.Errorf("%s : etagChecksum() found a checksum = %v, want %v", name, got, tt.want)
+		}
+	}
+}
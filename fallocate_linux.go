@@ -0,0 +1,13 @@
+package summon
+
+import (
+	"os"
+	"syscall"
+)
+
+func fallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return syscall.Fallocate(int(f.Fd()), 0, 0, size)
+}
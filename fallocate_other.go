@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package summon
+
+import (
+	"errors"
+	"os"
+)
+
+func fallocate(f *os.File, size int64) error {
+	return errors.New("fallocate is not supported")
+}
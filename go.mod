@@ -1,3 +1,5 @@
 module github.com/akshaykhairmode/summon
 
 go 1.16
+
+require golang.org/x/crypto v0.5.0
@@ -0,0 +1,143 @@
+package summon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const LOCK_POLL_INTERVAL = 500 * time.Millisecond //how often a waiting process tries to take the lock again
+
+//ErrLocked is returned by Run when another process is downloading the same file and the lock mode is LOCK_FAIL
+var ErrLocked = errors.New("file is being downloaded by another process")
+
+//LockMode decides what is done when another process is downloading the same file
+type LockMode int
+
+const (
+	LOCK_FAIL   LockMode = iota + 1 //fail with ErrLocked
+	LOCK_WAIT                       //wait for the other process to finish and then download as usual
+	LOCK_ATTACH                     //wait for the other process to finish and use the file it downloaded, download only if it did not finish
+)
+
+var lockModes = map[LockMode]string{
+	LOCK_FAIL:   "fail",
+	LOCK_WAIT:   "wait",
+	LOCK_ATTACH: "attach",
+}
+
+func (lm LockMode) String() string {
+	return lockModes[lm]
+}
+
+//ParseLockMode parses the name of the lock mode which can be fail, wait or attach
+func ParseLockMode(name string) (LockMode, error) {
+
+	for lm, n := range lockModes {
+		if strings.EqualFold(name, n) {
+			return lm, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid lock mode : %q, it can be fail, wait or attach", name)
+}
+
+func (sum *Downloader) getLockFileName() string {
+	return sum.fileDetails.fileDir + sum.separator + "." + sum.fileDetails.fileName + ".summon.lock"
+}
+
+//lockOutput takes the lock of the output file so that two processes do not write the same partial files.
+//done is true when the mode is LOCK_ATTACH and the other process has downloaded the file
+func (sum *Downloader) lockOutput(ctx context.Context) (done bool, err error) {
+
+	path := sum.getLockFileName()
+	waited := false
+
+	for {
+		f, err := lockFile(path)
+		if err != nil {
+			return false, fmt.Errorf("error while locking %v : %v", path, err)
+		}
+
+		if f != nil {
+			sum.lock = f
+			break
+		}
+
+		pid := readLockPID(path)
+
+		if sum.lockMode == LOCK_FAIL {
+			return false, fmt.Errorf("%w, pid %d : %v", ErrLocked, pid, sum.fileDetails.absolutePath)
+		}
+
+		if !waited {
+			sum.log.Printf("Waiting for process %d which is downloading the same file", pid)
+			waited = true
+		}
+
+		if err := sleepContext(ctx, LOCK_POLL_INTERVAL); err != nil {
+			return false, ErrGracefulShutdown
+		}
+	}
+
+	//the lock file is removed when unlocking, a pid is left in it only by a process which crashed
+	if pid := readLockPID(path); pid > 0 && pid != os.Getpid() {
+		sum.log.Printf("Taking over the stale lock of process %d which is not running", pid)
+	}
+
+	if err := writeLockPID(sum.lock); err != nil {
+		sum.unlockOutput()
+		return false, fmt.Errorf("error while writing lock file : %v", err)
+	}
+
+	if waited && sum.lockMode == LOCK_ATTACH && fileExists(sum.fileDetails.absolutePath) {
+		sum.log.Printf("File was downloaded by the other process : %v", sum.fileDetails.absolutePath)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+//unlockOutput removes the lock file and releases the lock
+func (sum *Downloader) unlockOutput() {
+
+	if sum.lock == nil {
+		return
+	}
+
+	//removed while holding the lock, a process waiting for it checks that the file it locked is still the lock file
+	os.Remove(sum.lock.Name())
+	sum.lock.Close()
+	sum.lock = nil
+}
+
+//readLockPID returns the pid written in the lock file, 0 if it cannot be read
+func readLockPID(path string) int {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+func writeLockPID(f *os.File) error {
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	return err
+}
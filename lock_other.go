@@ -0,0 +1,58 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package summon
+
+import (
+	"os"
+)
+
+//lockFile creates the lock file if it does not exist, the file is nil if another process holds it.
+//Without flock the file is left when the process crashes so it is removed if the process in it is not running
+func lockFile(path string) (*os.File, error) {
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err == nil {
+		return f, nil
+	}
+
+	if !os.IsExist(err) {
+		return nil, err
+	}
+
+	//written right after creating, the file can be empty only for a moment
+	if pid := readLockPID(path); pid > 0 && !processRunning(pid) {
+		os.Remove(path)
+		if f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644); err == nil {
+			return f, nil
+		}
+	}
+
+	return nil, nil
+}
+
+//lockHeld tells if a process holds the lock, the lock file of a process which crashed has the pid of a process which is not running
+func lockHeld(path string) bool {
+
+	if !fileExists(path) {
+		return false
+	}
+
+	//written right after creating, the file can be empty only for a moment
+	pid := readLockPID(path)
+
+	return pid == 0 || processRunning(pid)
+}
+
+//processRunning tells if the process exists, finding a process fails on windows when it does not exist
+func processRunning(pid int) bool {
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	p.Release()
+
+	return true
+}
@@ -0,0 +1,111 @@
+package summon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//holdLock takes the lock of the output like another process would and releases it after the delay, before releasing the
+//output is written if done is true
+func holdLock(t *testing.T, out string, delay time.Duration, done bool) {
+
+	t.Helper()
+
+	path := filepath.Join(filepath.Dir(out), "."+filepath.Base(out)+".summon.lock")
+
+	f, err := lockFile(path)
+	if err != nil || f == nil {
+		t.Fatalf("cannot take the lock : %v", err)
+	}
+	writeLockPID(f)
+
+	go func() {
+		time.Sleep(delay)
+		if done {
+			os.WriteFile(out, []byte("done"), 0644)
+		}
+		os.Remove(path)
+		f.Close()
+	}()
+}
+
+func TestLockModes(t *testing.T) {
+
+	size := MiB
+	srv := newRangeServer(t, size, true)
+
+	tests := []struct {
+		mode    LockMode
+		done    bool //other process finishes the download
+		wantErr error
+		want    int64 //size of the output
+	}{
+		{mode: LOCK_FAIL, wantErr: ErrLocked},
+		{mode: LOCK_WAIT, want: size},
+		{mode: LOCK_ATTACH, done: true, want: 4},
+		{mode: LOCK_ATTACH, want: size},
+	}
+
+	for _, tt := range tests {
+
+		out := filepath.Join(t.TempDir(), "file.bin")
+		holdLock(t, out, 300*time.Millisecond, tt.done)
+
+		sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithLockMode(tt.mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = sum.Run(context.Background())
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%v : Run() = %v, want %v", tt.mode, err, tt.wantErr)
+		}
+
+		if tt.wantErr != nil {
+			continue
+		}
+
+		fi, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Size() != tt.want {
+			t.Fatalf("%v : file size is %d, want %d", tt.mode, fi.Size(), tt.want)
+		}
+
+		if fileExists(sum.getLockFileName()) {
+			t.Fatalf("%v : lock file is not removed", tt.mode)
+		}
+	}
+}
+
+//TestStaleLock downloads when the lock file of a crashed process is left
+func TestStaleLock(t *testing.T) {
+
+	srv := newRangeServer(t, MiB, true)
+	out := filepath.Join(t.TempDir(), "file.bin")
+	lock := filepath.Join(filepath.Dir(out), ".file.bin.summon.lock")
+
+	//pids are smaller than this on every platform
+	if err := os.WriteFile(lock, []byte("2147483646\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if fileExists(lock) {
+		t.Fatal("lock file is not removed")
+	}
+}
@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package summon
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+//lockFile takes the flock of the file without waiting, the file is nil if another process holds it.
+//flock is released by the kernel when the process dies so a lock is never left by a crashed process
+func lockFile(path string) (*os.File, error) {
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	//the holder removes the file before unlocking, the file which is locked should still be the lock file
+	locked, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if current, err := os.Stat(path); err != nil || !os.SameFile(locked, current) {
+		f.Close()
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+//lockHeld tells if a process holds the lock, the lock is taken shared for a moment to check it
+func lockHeld(path string) bool {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	return errors.Is(syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB), syscall.EWOULDBLOCK)
+}
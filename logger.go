@@ -1,4 +1,4 @@
-package main
+package summon
 
 import "log"
 
@@ -30,16 +30,3 @@ func (pl ProdLogger) Println(args ...interface{}) {
 func (dl DevLogger) Println(args ...interface{}) {
 	log.Println(args...)
 }
-
-//LogWriter will hold our logger
-var LogWriter Logger
-
-func setLogWriter(v bool) {
-
-	if v {
-		LogWriter = DevLogger{}
-		return
-	}
-
-	LogWriter = ProdLogger{}
-}
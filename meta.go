@@ -0,0 +1,243 @@
+package summon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	META_VERSION        = 2               //version of the meta file written by this version, 1 is the older base64 format
+	CHECKPOINT_INTERVAL = 5 * time.Second //how often the progress is saved to the meta file while downloading
+)
+
+//meta is the state of an incomplete download which is saved in the meta file as indented json
+type meta struct {
+	Version     int           `json:"version"`
+	URL         string        `json:"url"`                //url which was passed, without the credentials
+	FinalURL    string        `json:"finalURL,omitempty"` //url after following the redirects
+	Size        int64         `json:"size"`               //size of the file, -1 when not known
+	Direct      bool          `json:"direct"`             //chunks are written directly into the output file instead of part files
+	Connections int64         `json:"connections"`        //number of connections which were used
+	Chunks      []metaChunk   `json:"chunks"`             //ordered by index
+	Checksum    *metaChecksum `json:"checksum,omitempty"` //state of the checksum of the bytes written in order
+	Updated     time.Time     `json:"updated"`            //time of the checkpoint
+
+	//the file on the server which was being downloaded, compared with the server on resume
+	ETag         string `json:"etag,omitempty"`         //ETag of the file, empty if not sent
+	LastModified string `json:"lastModified,omitempty"` //Last-Modified of the file, empty if not sent
+}
+
+//metaChunk is a range of the file and the bytes of it which are safely on the disk
+type metaChunk struct {
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Done  int64  `json:"done"`           //-1 when not known, the size of the part file is used then
+	Path  string `json:"path,omitempty"` //part file, empty when writing directly into the output file
+}
+
+//metaChecksum is the state of the hash of the first Offset bytes of the file
+type metaChecksum struct {
+	Algo   string `json:"algo"`
+	Offset int64  `json:"offset"`
+	State  []byte `json:"state"`
+}
+
+//metaV1 is the base64 encoded meta file of the older versions
+type metaV1 struct {
+	ChunkPaths   map[int64]string  `json:"chunkPaths"`
+	Range        map[int64][]int64 `json:"range"`
+	Direct       bool              `json:"direct,omitempty"`
+	Downloaded   map[int64]int64   `json:"downloaded,omitempty"`
+	Connections  int64             `json:"connections,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"lastModified,omitempty"`
+	Size         int64             `json:"size"`
+	FinalURL     string            `json:"finalURL,omitempty"`
+}
+
+//readMeta reads the meta file, the older versions are migrated to the current one
+func readMeta(path string) (meta, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return meta{}, err
+	}
+
+	//the older versions are base64 encoded
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		return migrateMetaV1(data)
+	}
+
+	m := meta{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return meta{}, fmt.Errorf("invalid meta file : %v", err)
+	}
+
+	if m.Version != META_VERSION {
+		return meta{}, fmt.Errorf("meta file version %d is not supported, version %d is supported", m.Version, META_VERSION)
+	}
+
+	for i, c := range m.Chunks {
+		if c.Start < 0 || c.End < c.Start || c.Done > c.End-c.Start+1 {
+			return meta{}, fmt.Errorf("chunk %d in meta file is invalid : %+v", i, c)
+		}
+	}
+
+	return m, nil
+}
+
+//migrateMetaV1 converts the base64 encoded meta file of the older versions to the current version
+func migrateMetaV1(data []byte) (meta, error) {
+
+	decoded, err := decode(data)
+	if err != nil {
+		return meta{}, fmt.Errorf("invalid meta file : %v", err)
+	}
+
+	old := metaV1{}
+	if err := json.Unmarshal(decoded, &old); err != nil {
+		return meta{}, fmt.Errorf("invalid meta file : %v", err)
+	}
+
+	m := meta{
+		Version:      META_VERSION,
+		FinalURL:     old.FinalURL,
+		Size:         old.Size,
+		Direct:       old.Direct,
+		Connections:  old.Connections,
+		Chunks:       make([]metaChunk, len(old.Range)),
+		ETag:         old.ETag,
+		LastModified: old.LastModified,
+	}
+
+	//size was not saved by the first versions
+	if old.FinalURL == "" {
+		m.Size = -1
+	}
+
+	for index := int64(0); index < int64(len(old.Range)); index++ {
+
+		r, ok := old.Range[index]
+		if !ok || len(r) != 2 {
+			return meta{}, fmt.Errorf("range for segment %d is missing in meta file", index)
+		}
+
+		c := metaChunk{Start: r[0], End: r[1], Done: -1, Path: old.ChunkPaths[index]}
+
+		//the part files were not synced so their size is used
+		if done, ok := old.Downloaded[index]; ok && old.Direct {
+			c.Done = done
+		}
+
+		m.Chunks[index] = c
+	}
+
+	m.fixLegacyEnd(m.Size)
+
+	return m, nil
+}
+
+//fixLegacyEnd corrects the last range of the meta files of the first versions, it ended at the size of the file instead of the
+//last byte. The size was not saved by them so it is called again once the size is known from the server
+func (m *meta) fixLegacyEnd(size int64) {
+
+	if size <= 0 || len(m.Chunks) == 0 {
+		return
+	}
+
+	last := &m.Chunks[len(m.Chunks)-1]
+	if last.End != size {
+		return
+	}
+
+	last.End = size - 1
+	if length := last.End - last.Start + 1; last.Done > length {
+		last.Done = length
+	}
+}
+
+//paths returns the part files of the chunks
+func (m meta) paths() []string {
+
+	paths := []string{}
+
+	for _, c := range m.Chunks {
+		if c.Path != "" {
+			paths = append(paths, c.Path)
+		}
+	}
+
+	return paths
+}
+
+//ranges returns the ranges of the chunks, the end of a file of unknown size is set if the size is known now
+func (m meta) ranges(size int64) []byteRange {
+
+	ranges := make([]byteRange, 0, len(m.Chunks))
+
+	for _, c := range m.Chunks {
+		r := byteRange{start: c.Start, end: c.End}
+		if r.end == UNKNOWN_END && size >= 0 {
+			r.end = size - 1
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+//writeMeta writes the meta file so that a crash at any time leaves either the previous or the new meta file,
+//it is written to a temporary file which is synced and then renamed over the meta file
+func writeMeta(path string, m meta) error {
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error while marshalling meta data : %v", err)
+	}
+
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error while creating meta file : %v", err)
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error while writing meta file : %v", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error while renaming meta file : %v", err)
+	}
+
+	syncDir(filepath.Dir(path))
+
+	return nil
+}
+
+//syncDir makes the rename durable, it is not supported on every platform so the error is ignored
+func syncDir(dir string) {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	d.Sync()
+	d.Close()
+}
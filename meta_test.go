@@ -0,0 +1,448 @@
+package summon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteReadMeta(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), ".file.bin.summon.meta")
+
+	want := meta{
+		Version:     META_VERSION,
+		URL:         "http://example.com/file.bin",
+		Size:        10,
+		Connections: 2,
+		Chunks:      []metaChunk{{Start: 0, End: 4, Done: 4, Path: "/tmp/.file.bin.sump0"}, {Start: 5, End: 9, Done: 0, Path: "/tmp/.file.bin.sump1"}},
+		Checksum:    &metaChecksum{Algo: "sha256", Offset: 4, State: []byte{1, 2, 3}},
+	}
+
+	if err := writeMeta(path, want); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(data, []byte(`"url": "http://example.com/file.bin"`)) {
+		t.Fatalf("meta file is not readable json :\n%s", data)
+	}
+
+	if fileExists(path + ".tmp") {
+		t.Fatal("temporary meta file is left")
+	}
+
+	got, err := readMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.URL != want.URL || len(got.Chunks) != 2 || got.Chunks[1] != want.Chunks[1] || got.Checksum.Offset != 4 {
+		t.Fatalf("readMeta() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadMetaRejects(t *testing.T) {
+
+	tests := map[string]string{
+		"newer version": `{"version": 3, "chunks": []}`,
+		"no version":    `{"chunks": []}`,
+		"invalid json":  `{"version": 2,`,
+		"done too big":  `{"version": 2, "chunks": [{"start": 0, "end": 9, "done": 11}]}`,
+		"end before":    `{"version": 2, "chunks": [{"start": 5, "end": 4, "done": 0}]}`,
+		"invalid v1":    `not base64 !`,
+	}
+
+	dir := t.TempDir()
+
+	for name, content := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_"))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readMeta(path); err == nil {
+			t.Errorf("%s : readMeta() did not fail", name)
+		}
+	}
+}
+
+//baselineRanges returns the ranges written by the first versions, the last range ends at the size instead of the last byte
+func baselineRanges(size, connections int64) map[int64][]int64 {
+
+	ranges := map[int64][]int64{}
+	split := size / connections
+
+	for index, start := int64(0), int64(0); start < size; index, start = index+1, start+split+1 {
+		end := start + split
+		if end > size {
+			end = size
+		}
+		ranges[index] = []int64{start, end}
+	}
+
+	return ranges
+}
+
+//saveMetaV1 writes the base64 encoded meta file of the older versions
+func saveMetaV1(t *testing.T, path string, old metaV1) {
+
+	t.Helper()
+
+	data, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(data)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateMetaV1(t *testing.T) {
+
+	size := 4*MiB + 2
+	ranges := baselineRanges(size, 4)
+
+	if last := ranges[3]; len(ranges) != 4 || last[0] != 3145731 || last[1] != size {
+		t.Fatalf("baseline ranges are %v", ranges)
+	}
+
+	path := filepath.Join(t.TempDir(), ".f.summon.meta")
+	paths := map[int64]string{0: "/tmp/.f.sump0", 1: "/tmp/.f.sump1", 2: "/tmp/.f.sump2", 3: "/tmp/.f.sump3"}
+
+	//the first versions did not save the size, the last end is corrected when resuming
+	saveMetaV1(t, path, metaV1{ChunkPaths: paths, Range: ranges})
+
+	m, err := readMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := metaChunk{Start: 1048577, End: 2097153, Done: -1, Path: "/tmp/.f.sump1"}
+	if m.Version != META_VERSION || m.Size != -1 || len(m.Chunks) != 4 || m.Chunks[1] != want || m.Chunks[3].End != size {
+		t.Fatalf("readMeta() = %+v, want chunk %+v", m, want)
+	}
+
+	//later versions saved the size
+	saveMetaV1(t, path, metaV1{ChunkPaths: paths, Range: ranges, Size: size, FinalURL: "http://example.com/f"})
+
+	if m, err = readMeta(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Size != size || m.Chunks[3].End != size-1 {
+		t.Fatalf("readMeta() = %+v, want the last range to end at %d", m, size-1)
+	}
+}
+
+//TestResumeBaselineMeta resumes the part files and the meta file written by the first versions
+func TestResumeBaselineMeta(t *testing.T) {
+
+	size := 4*MiB + 2
+	srv := newRangeServer(t, size, true)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "file.bin")
+	ranges := baselineRanges(size, 4)
+	paths := map[int64]string{}
+
+	//the first part is not the content of the file so that a resume can be told from a new download
+	for index, r := range ranges {
+		paths[index] = filepath.Join(dir, fmt.Sprintf(".file.bin.sump%d", index))
+		part := make([]byte, 1000)
+		(&virtualFile{size: r[0] + 1000, off: r[0]}).Read(part)
+		if index == 0 {
+			part = bytes.Repeat([]byte{0xff}, 1000)
+		}
+		if err := os.WriteFile(paths[index], part, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saveMetaV1(t, filepath.Join(dir, ".file.bin.summon.meta"), metaV1{ChunkPaths: paths, Range: ranges})
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(4), WithResumePolicy(RESUME_AUTO))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if fi, _ := f.Stat(); fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	first := make([]byte, 1)
+	if f.ReadAt(first, 0); first[0] != 0xff {
+		t.Fatal("part files were not resumed")
+	}
+
+	checkRange(t, f, 1000, size-1000)
+}
+
+//TestResumeMismatchedMeta checks that a meta file with ranges for another size is downloaded again without leaving its part files
+func TestResumeMismatchedMeta(t *testing.T) {
+
+	size := 2 * MiB
+	srv := newRangeServer(t, size, true)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "file.bin")
+
+	saveMeta(t, filepath.Join(dir, ".file.bin.summon.meta"), meta{
+		URL:         srv.URL + "/file.bin",
+		Size:        -1,
+		Connections: 3,
+		Chunks: []metaChunk{
+			{Start: 0, End: 99, Done: 10, Path: filepath.Join(dir, ".file.bin.sump0")},
+			{Start: 100, End: 199, Done: 10, Path: filepath.Join(dir, ".file.bin.sump1")},
+			{Start: 200, End: 299, Done: 10, Path: filepath.Join(dir, ".file.bin.sump7")},
+		},
+	})
+
+	for _, name := range []string{".file.bin.sump0", ".file.bin.sump1", ".file.bin.sump7"} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2), WithResumePolicy(RESUME_AUTO))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("%d files are left in the directory, want only the output", len(entries))
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	checkRange(t, f, 0, size)
+}
+
+//TestResumeAfterCrash resumes part files which have more bytes than the last checkpoint, as after a crash, the extra bytes are downloaded again
+func TestResumeAfterCrash(t *testing.T) {
+
+	size := 2*MiB + 5
+	dir := t.TempDir()
+	out := filepath.Join(dir, "file.bin")
+	parts := []string{filepath.Join(dir, ".file.bin.sump0"), filepath.Join(dir, ".file.bin.sump1")}
+
+	//first part has its checkpointed bytes and garbage after them
+	first := make([]byte, MiB/2)
+	(&virtualFile{size: size}).Read(first)
+	if err := os.WriteFile(parts[0], append(first, bytes.Repeat([]byte{0xee}, 1000)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(parts[1], bytes.Repeat([]byte{0xee}, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".file.bin"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newRangeServer(t, size, true)
+
+	saveMeta(t, filepath.Join(dir, ".file.bin.summon.meta"), meta{
+		URL:         srv.URL + "/file.bin",
+		Size:        size,
+		Connections: 2,
+		Chunks:      []metaChunk{{Start: 0, End: MiB - 1, Done: MiB / 2, Path: parts[0]}, {Start: MiB, End: size - 1, Done: 0, Path: parts[1]}},
+	})
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, _ := f.Stat()
+	if fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 0, size)
+}
+
+//TestResumeAfterCrashWhileCombining resumes a download whose part files are complete but which crashed while combining them,
+//the temp output has the bytes of the combine which did not finish
+func TestResumeAfterCrashWhileCombining(t *testing.T) {
+
+	size := 2 * MiB
+	dir := t.TempDir()
+	out := filepath.Join(dir, "file.bin")
+	parts := []string{filepath.Join(dir, ".file.bin.sump0"), filepath.Join(dir, ".file.bin.sump1")}
+
+	for i, part := range parts {
+		b := make([]byte, MiB)
+		(&virtualFile{size: size, off: int64(i) * MiB}).Read(b)
+		if err := os.WriteFile(part, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".file.bin"), bytes.Repeat([]byte{0xee}, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newRangeServer(t, size, true)
+
+	saveMeta(t, filepath.Join(dir, ".file.bin.summon.meta"), meta{
+		URL:         srv.URL + "/file.bin",
+		Size:        size,
+		Connections: 2,
+		Chunks:      []metaChunk{{Start: 0, End: MiB - 1, Done: MiB, Path: parts[0]}, {Start: MiB, End: size - 1, Done: MiB, Path: parts[1]}},
+	})
+
+	h := sha256.New()
+	io.Copy(h, &virtualFile{size: size})
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2), WithChecksum(fmt.Sprintf("sha256:%x", h.Sum(nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, _ := f.Stat()
+	if fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 0, size)
+}
+
+func TestChecksumState(t *testing.T) {
+
+	data := bytes.Repeat([]byte("summon"), 100000)
+	sum := sha256.Sum256(data)
+
+	c, err := newChecksum("sha256", sum[:], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.writeAt(data[:1000], 0)
+	state := c.state()
+
+	restored, _ := newChecksum("sha256", sum[:], "")
+
+	if restored.restore(state, 999) {
+		t.Fatal("state is restored past the downloaded bytes")
+	}
+
+	if !restored.restore(state, int64(len(data))) {
+		t.Fatal("state is not restored")
+	}
+
+	if err := restored.verify(bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := newChecksum("md5", make([]byte, 16), "")
+	if other.restore(state, int64(len(data))) {
+		t.Fatal("state of sha256 is restored for md5")
+	}
+}
+
+//TestResumeMoreConnections resumes a download started with one connection using four, what is left is cut across them
+func TestResumeMoreConnections(t *testing.T) {
+
+	size := 4*MiB + 3
+	dir := t.TempDir()
+	out := filepath.Join(dir, "file.bin")
+	part := filepath.Join(dir, ".file.bin.sump0")
+
+	first := make([]byte, MiB)
+	(&virtualFile{size: size}).Read(first)
+	if err := os.WriteFile(part, first, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".file.bin"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newRangeServer(t, size, true)
+
+	saveMeta(t, filepath.Join(dir, ".file.bin.summon.meta"), meta{
+		URL:         srv.URL + "/file.bin",
+		Size:        size,
+		Connections: 1,
+		Chunks:      []metaChunk{{Start: 0, End: size - 1, Done: MiB, Path: part}},
+	})
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(sum.scheduler.segments); n < 4 {
+		t.Fatalf("remaining bytes are downloaded in %d segments, want at least 4", n)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, _ := f.Stat()
+	if fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 0, size)
+}
@@ -0,0 +1,224 @@
+package summon
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//partialFile matches the files summon leaves next to the output of an incomplete download, the first group is the output name
+var partialFile = regexp.MustCompile(`^\.(.+?)(\.summon\.meta|\.summon\.meta\.tmp|\.summon\.lock|\.sump\d+)$`)
+
+//Partial is the state an incomplete download left next to its output
+type Partial struct {
+	Output    string    //path of the output file
+	URL       string    //empty when the meta file is missing or cannot be read
+	Size      int64     //size of the file, -1 when not known
+	Done      int64     //bytes downloaded, -1 when not known
+	Updated   time.Time //time of the last checkpoint, the latest modification of the files when the meta file does not have it
+	DiskUsage int64     //bytes used by the files on the disk
+	Files     []string  //meta, lock, temporary output and part files
+	Locked    bool      //another process is downloading it now
+	Err       error     //error while reading the meta file
+}
+
+//Percent returns how much of the file is downloaded, -1 when the size or the bytes downloaded are not known
+func (p Partial) Percent() float64 {
+
+	if p.Size <= 0 || p.Done < 0 {
+		return -1
+	}
+
+	return float64(p.Done) * 100 / float64(p.Size)
+}
+
+//Remove deletes all the files of the incomplete download, the download starts from the beginning next time
+func (p Partial) Remove() error {
+
+	var errs []string
+
+	for _, f := range p.Files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+//FindPartials walks the directory tree and returns the incomplete downloads found in it ordered by output path.
+//The root can also be the output path of a download, only its state is returned then. Directories which cannot be read
+//are skipped and passed to skipped if it is not nil, an error is returned only for the root
+func FindPartials(root string, skipped func(path string, err error)) ([]Partial, error) {
+
+	info, err := os.Stat(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	//output path, it does not exist until the download completes
+	if err != nil || !info.IsDir() {
+		dir, name := filepath.Dir(root), filepath.Base(root)
+		found, ferr := findPartialsInDir(dir)
+		if ferr != nil && !os.IsNotExist(ferr) {
+			return nil, ferr
+		}
+		for _, p := range found {
+			if filepath.Base(p.Output) == name {
+				return []Partial{p}, nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v does not exist", root)
+		}
+		return nil, nil
+	}
+
+	partials := []Partial{}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			if skipped != nil {
+				skipped(path, err)
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		found, err := findPartialsInDir(path)
+		if err != nil {
+			if path == root {
+				return err
+			}
+			if skipped != nil {
+				skipped(path, err)
+			}
+			//the contents are not read again by WalkDir
+			return fs.SkipDir
+		}
+		partials = append(partials, found...)
+		return nil
+	})
+
+	return partials, err
+}
+
+//findPartialsInDir returns the incomplete downloads of the outputs in the directory, it does not look in the subdirectories
+func findPartialsInDir(dir string) ([]Partial, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, e := range entries {
+		if m := partialFile.FindStringSubmatch(e.Name()); m != nil && !e.IsDir() {
+			names[m[1]] = true
+		}
+	}
+
+	partials := []Partial{}
+
+	for name := range names {
+		partials = append(partials, readPartial(dir, name, entries))
+	}
+
+	sort.Slice(partials, func(i, j int) bool {
+		return partials[i].Output < partials[j].Output
+	})
+
+	return partials, nil
+}
+
+//readPartial collects the files of the output and reads its meta file
+func readPartial(dir, name string, entries []fs.DirEntry) Partial {
+
+	p := Partial{Output: filepath.Join(dir, name), Size: -1, Done: -1}
+
+	sizes := map[string]int64{}
+
+	for _, e := range entries {
+		//the temporary output file has no suffix, it is found only along with the other files
+		if m := partialFile.FindStringSubmatch(e.Name()); (m == nil || m[1] != name) && e.Name() != "."+name {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+		p.Files = append(p.Files, path)
+		p.DiskUsage += info.Size()
+		sizes[e.Name()] = info.Size()
+
+		if info.ModTime().After(p.Updated) {
+			p.Updated = info.ModTime()
+		}
+	}
+
+	lockPath := filepath.Join(dir, "."+name+".summon.lock")
+	if _, ok := sizes[filepath.Base(lockPath)]; ok {
+		p.Locked = lockHeld(lockPath)
+	}
+
+	metaPath := filepath.Join(dir, "."+name+".summon.meta")
+	if _, ok := sizes[filepath.Base(metaPath)]; !ok {
+		return p
+	}
+
+	m, err := readMeta(metaPath)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+
+	p.URL = m.URL
+	if p.URL == "" {
+		p.URL = m.FinalURL
+	}
+
+	p.Size = m.Size
+	if !m.Updated.IsZero() {
+		p.Updated = m.Updated
+	}
+
+	p.Done = 0
+	for _, c := range m.Chunks {
+
+		done := c.Done
+		if done < 0 {
+			//the part file is looked up in this directory as the tree may have been moved since
+			size, ok := sizes[filepath.Base(c.Path)]
+			if c.Path == "" || !ok {
+				p.Done = -1
+				break
+			}
+			done = size
+		}
+
+		if length := c.End - c.Start + 1; c.End != UNKNOWN_END && done > length {
+			done = length
+		}
+
+		p.Done += done
+	}
+
+	return p
+}
@@ -0,0 +1,157 @@
+package summon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindPartials(t *testing.T) {
+
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	os.Mkdir(sub, 0755)
+
+	write := func(path string, size int) {
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//a part file has 3 bytes more than its checkpoint, done is taken from the meta file
+	updated := time.Now().Add(-time.Hour).Round(time.Second)
+	saveMeta(t, filepath.Join(sub, ".a.bin.summon.meta"), meta{
+		URL:     "http://example.com/a.bin",
+		Size:    20,
+		Updated: updated,
+		Chunks: []metaChunk{
+			{Start: 0, End: 9, Done: 5, Path: "/moved/.a.bin.sump0"},
+			{Start: 10, End: 19, Done: -1, Path: "/moved/.a.bin.sump1"},
+		},
+	})
+	write(filepath.Join(sub, ".a.bin"), 0)
+	write(filepath.Join(sub, ".a.bin.sump0"), 8)
+	write(filepath.Join(sub, ".a.bin.sump1"), 4)
+
+	//part file without a meta file and files which are not summon's
+	write(filepath.Join(root, ".b.iso.sump3"), 7)
+	write(filepath.Join(root, ".bashrc"), 1)
+	write(filepath.Join(root, ".c.txt"), 1)
+
+	partials, err := FindPartials(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(partials) != 2 {
+		t.Fatalf("found %d partials, want 2 : %+v", len(partials), partials)
+	}
+
+	b, a := partials[0], partials[1]
+
+	if b.Output != filepath.Join(root, "b.iso") || b.URL != "" || b.Done != -1 || b.Percent() != -1 || b.DiskUsage != 7 || len(b.Files) != 1 {
+		t.Fatalf("partial without meta = %+v", b)
+	}
+
+	if a.Output != filepath.Join(sub, "a.bin") || a.URL != "http://example.com/a.bin" || a.Done != 9 || a.Percent() != 45 {
+		t.Fatalf("partial = %+v, want 9 of 20 bytes done", a)
+	}
+
+	if !a.Updated.Equal(updated) || a.DiskUsage != 12+fileSize(t, filepath.Join(sub, ".a.bin.summon.meta")) || len(a.Files) != 4 || a.Locked {
+		t.Fatalf("partial = %+v", a)
+	}
+
+	//the output path finds only its own state
+	partials, err = FindPartials(filepath.Join(sub, "a.bin"), nil)
+	if err != nil || len(partials) != 1 || partials[0].Output != a.Output {
+		t.Fatalf("FindPartials(output) = %+v, %v", partials, err)
+	}
+
+	if err := a.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(sub)
+	if len(entries) != 0 {
+		t.Fatalf("%d files are left after removing", len(entries))
+	}
+}
+
+func TestFindPartialsLocked(t *testing.T) {
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+	holdLock(t, out, time.Second, false)
+
+	partials, err := FindPartials(out, nil)
+	if err != nil || len(partials) != 1 {
+		t.Fatalf("FindPartials() = %+v, %v", partials, err)
+	}
+
+	if !partials[0].Locked {
+		t.Fatal("partial being downloaded is not locked")
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Size()
+}
+
+func TestFindPartialsSkipsUnreadable(t *testing.T) {
+
+	if os.Geteuid() == 0 {
+		t.Skip("root can read every directory")
+	}
+
+	root := t.TempDir()
+	locked := filepath.Join(root, "locked")
+	os.Mkdir(locked, 0755)
+
+	if err := os.WriteFile(filepath.Join(root, ".a.bin.sump0"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Chmod(locked, 0)
+	defer os.Chmod(locked, 0755)
+
+	var skipped []string
+	partials, err := FindPartials(root, func(path string, err error) {
+		skipped = append(skipped, path)
+	})
+
+	if err != nil || len(partials) != 1 {
+		t.Fatalf("FindPartials() = %+v, %v, want the partial next to the unreadable directory", partials, err)
+	}
+
+	if len(skipped) != 1 || skipped[0] != locked {
+		t.Fatalf("skipped %v, want %v", skipped, locked)
+	}
+}
+
+func TestFindPartialsMissing(t *testing.T) {
+
+	dir := t.TempDir()
+
+	for _, path := range []string{filepath.Join(dir, "missing"), filepath.Join(dir, "missing", "file.bin")} {
+		if _, err := FindPartials(path, nil); err == nil {
+			t.Errorf("FindPartials(%v) did not fail", path)
+		}
+	}
+
+	//an output which is not downloaded yet
+	if err := os.WriteFile(filepath.Join(dir, ".file.bin.sump0"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if partials, err := FindPartials(filepath.Join(dir, "file.bin"), nil); err != nil || len(partials) != 1 {
+		t.Errorf("FindPartials() = %+v, %v, want the partial of the output", partials, err)
+	}
+}
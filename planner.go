@@ -0,0 +1,153 @@
+package summon
+
+import (
+	"fmt"
+	"sort"
+)
+
+//byteRange is an inclusive range of bytes of the file
+type byteRange struct {
+	start int64 //first byte
+	end   int64 //last byte
+}
+
+//size returns the number of bytes in the range
+func (r byteRange) size() int64 {
+	return r.end - r.start + 1
+}
+
+//rangePlanner cuts the file into the ranges downloaded by the connections
+type rangePlanner struct {
+	size        int64 //size of the file, -1 when not known
+	connections int64 //number of connections
+	ranges      bool  //if the server supports range requests, the file is one range without it
+	perConn     int64 //ranges per connection so that the faster connections can take more of them
+	minSize     int64 //ranges are not made smaller than this
+}
+
+func newRangePlanner(size, connections int64, ranges bool) rangePlanner {
+	return rangePlanner{
+		size:        size,
+		connections: connections,
+		ranges:      ranges,
+		perConn:     SEGMENTS_PER_CONN,
+		minSize:     MIN_SEGMENT_SIZE,
+	}
+}
+
+//plan returns the ranges ordered by start, they do not overlap and cover the whole file.
+//A zero byte file has no ranges and a file whose size is not known is one range till UNKNOWN_END
+func (p rangePlanner) plan() []byteRange {
+
+	switch {
+	case p.size < 0:
+		return []byteRange{{start: 0, end: UNKNOWN_END}}
+	case p.size == 0:
+		return []byteRange{}
+	case !p.ranges:
+		return []byteRange{{start: 0, end: p.size - 1}}
+	}
+
+	n := p.connections * p.perConn
+	if limit := p.size / p.minSize; n > limit {
+		n = limit
+	}
+
+	//also when the file is smaller than the minimum size or than the connections
+	if n < 1 {
+		n = 1
+	}
+
+	ranges := make([]byteRange, 0, n)
+	size, extra := p.size/n, p.size%n
+
+	for i, start := int64(0), int64(0); i < n; i++ {
+		end := start + size - 1
+		//spread the remainder over the first ranges
+		if i < extra {
+			end++
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+		start = end + 1
+	}
+
+	return ranges
+}
+
+//validate checks that the ranges do not overlap and cover the whole file, they can be in any order
+func (p rangePlanner) validate(ranges []byteRange) error {
+
+	sorted := make([]byteRange, len(ranges))
+	copy(sorted, ranges)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].start < sorted[j].start
+	})
+
+	if p.size < 0 {
+		if len(sorted) != 1 || sorted[0].start != 0 || sorted[0].end != UNKNOWN_END {
+			return fmt.Errorf("file of unknown size should be one range from 0, got %v", sorted)
+		}
+		return nil
+	}
+
+	next := int64(0)
+	for _, r := range sorted {
+		switch {
+		case r.start < next:
+			return fmt.Errorf("range %d-%d overlaps the previous range which ends at %d", r.start, r.end, next-1)
+		case r.start > next:
+			return fmt.Errorf("bytes %d-%d are not in any range", next, r.start-1)
+		case r.end < r.start:
+			return fmt.Errorf("range %d-%d ends before it starts", r.start, r.end)
+		}
+		next = r.end + 1
+	}
+
+	if next != p.size {
+		return fmt.Errorf("ranges end at byte %d but the size is %d", next-1, p.size)
+	}
+
+	return nil
+}
+
+//split cuts the remaining ranges of a resumed download so that every connection has work again, the pieces of every range are
+//returned in order and the first piece of a range starts where the range starts. Ranges smaller than twice the minimum size are not cut
+func (p rangePlanner) split(remaining []byteRange) [][]byteRange {
+
+	var total int64
+	for _, r := range remaining {
+		total += r.size()
+	}
+
+	per := p.minSize
+	if n := p.connections * p.perConn; n > 0 && total/n > per {
+		per = total / n
+	}
+
+	pieces := make([][]byteRange, 0, len(remaining))
+
+	for _, r := range remaining {
+
+		n := r.size() / per
+		if n < 1 {
+			n = 1
+		}
+
+		size, extra := r.size()/n, r.size()%n
+		cut := make([]byteRange, 0, n)
+
+		for i, start := int64(0), r.start; i < n; i++ {
+			end := start + size - 1
+			if i < extra {
+				end++
+			}
+			cut = append(cut, byteRange{start: start, end: end})
+			start = end + 1
+		}
+
+		pieces = append(pieces, cut)
+	}
+
+	return pieces
+}
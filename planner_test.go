@@ -0,0 +1,154 @@
+package summon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		size        int64
+		connections int64
+		ranges      bool
+		want        []byteRange
+	}{
+		{name: "zero byte file", size: 0, connections: 4, ranges: true, want: []byteRange{}},
+		{name: "one byte", size: 1, connections: 4, ranges: true, want: []byteRange{{0, 0}}},
+		{name: "size less than connections", size: 3, connections: 8, ranges: true, want: []byteRange{{0, 2}}},
+		{name: "size less than minimum", size: MIN_SEGMENT_SIZE - 1, connections: 4, ranges: true, want: []byteRange{{0, MIN_SEGMENT_SIZE - 2}}},
+		{name: "no range support", size: 10 * MIN_SEGMENT_SIZE, connections: 4, ranges: false, want: []byteRange{{0, 10*MIN_SEGMENT_SIZE - 1}}},
+		{name: "unknown size", size: -1, connections: 4, ranges: true, want: []byteRange{{0, UNKNOWN_END}}},
+		{
+			name: "limited by minimum size", size: 2*MIN_SEGMENT_SIZE + 1, connections: 4, ranges: true,
+			want: []byteRange{{0, MIN_SEGMENT_SIZE}, {MIN_SEGMENT_SIZE + 1, 2 * MIN_SEGMENT_SIZE}},
+		},
+		{
+			name: "remainder spread over first ranges", size: 8*MIN_SEGMENT_SIZE + 2, connections: 2, ranges: true,
+			want: []byteRange{
+				{0, MIN_SEGMENT_SIZE},
+				{MIN_SEGMENT_SIZE + 1, 2*MIN_SEGMENT_SIZE + 1},
+				{2*MIN_SEGMENT_SIZE + 2, 3*MIN_SEGMENT_SIZE + 1},
+				{3*MIN_SEGMENT_SIZE + 2, 4*MIN_SEGMENT_SIZE + 1},
+				{4*MIN_SEGMENT_SIZE + 2, 5*MIN_SEGMENT_SIZE + 1},
+				{5*MIN_SEGMENT_SIZE + 2, 6*MIN_SEGMENT_SIZE + 1},
+				{6*MIN_SEGMENT_SIZE + 2, 7*MIN_SEGMENT_SIZE + 1},
+				{7*MIN_SEGMENT_SIZE + 2, 8*MIN_SEGMENT_SIZE + 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			p := newRangePlanner(tt.size, tt.connections, tt.ranges)
+			got := p.plan()
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("plan() = %v, want %v", got, tt.want)
+			}
+
+			if err := p.validate(got); err != nil {
+				t.Fatalf("validate() = %v", err)
+			}
+		})
+	}
+}
+
+func TestPlanCoversFile(t *testing.T) {
+
+	for size := int64(0); size < 3*MIN_SEGMENT_SIZE; size += MIN_SEGMENT_SIZE/3 + 1 {
+		for c := int64(1); c <= MAX_CONN; c++ {
+
+			p := newRangePlanner(size, c, true)
+			ranges := p.plan()
+
+			if err := p.validate(ranges); err != nil {
+				t.Fatalf("size %d, connections %d : %v", size, c, err)
+			}
+
+			var total int64
+			for _, r := range ranges {
+				total += r.size()
+			}
+
+			if total != size {
+				t.Fatalf("size %d, connections %d : ranges have %d bytes", size, c, total)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		size    int64
+		ranges  []byteRange
+		wantErr bool
+	}{
+		{name: "exact", size: 10, ranges: []byteRange{{0, 4}, {5, 9}}},
+		{name: "any order", size: 10, ranges: []byteRange{{5, 9}, {0, 4}}},
+		{name: "zero byte file", size: 0, ranges: []byteRange{}},
+		{name: "unknown size", size: -1, ranges: []byteRange{{0, UNKNOWN_END}}},
+		{name: "overlap", size: 10, ranges: []byteRange{{0, 5}, {5, 9}}, wantErr: true},
+		{name: "gap", size: 10, ranges: []byteRange{{0, 3}, {5, 9}}, wantErr: true},
+		{name: "one byte short", size: 10, ranges: []byteRange{{0, 4}, {5, 8}}, wantErr: true},
+		{name: "past the end", size: 10, ranges: []byteRange{{0, 4}, {5, 10}}, wantErr: true},
+		{name: "not from start", size: 10, ranges: []byteRange{{1, 9}}, wantErr: true},
+		{name: "end before start", size: 10, ranges: []byteRange{{0, 4}, {5, 3}, {5, 9}}, wantErr: true},
+		{name: "empty for non empty file", size: 10, ranges: []byteRange{}, wantErr: true},
+		{name: "unknown size with two ranges", size: -1, ranges: []byteRange{{0, 4}, {5, UNKNOWN_END}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newRangePlanner(tt.size, 4, true).validate(tt.ranges)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	p := newRangePlanner(100*MIN_SEGMENT_SIZE, 4, true)
+
+	remaining := []byteRange{
+		{0, 8*MIN_SEGMENT_SIZE - 1},
+		{50 * MIN_SEGMENT_SIZE, 50*MIN_SEGMENT_SIZE + 99},
+		{90 * MIN_SEGMENT_SIZE, 98*MIN_SEGMENT_SIZE + 4},
+	}
+
+	pieces := p.split(remaining)
+
+	if len(pieces) != len(remaining) {
+		t.Fatalf("got pieces for %d ranges, want %d", len(pieces), len(remaining))
+	}
+
+	total := 0
+	for i, cut := range pieces {
+
+		if cut[0].start != remaining[i].start || cut[len(cut)-1].end != remaining[i].end {
+			t.Fatalf("pieces %v do not cover range %v", cut, remaining[i])
+		}
+
+		for j := 1; j < len(cut); j++ {
+			if cut[j].start != cut[j-1].end+1 {
+				t.Fatalf("pieces %v of range %v are not contiguous", cut, remaining[i])
+			}
+			if cut[j].size() < MIN_SEGMENT_SIZE {
+				t.Fatalf("piece %v is smaller than the minimum", cut[j])
+			}
+		}
+
+		total += len(cut)
+	}
+
+	//the small range is not cut, the large ones are cut for 4 connections
+	if len(pieces[1]) != 1 || total < 8 || total > 4*SEGMENTS_PER_CONN+1 {
+		t.Fatalf("got %d pieces %v", total, pieces)
+	}
+}
@@ -0,0 +1,200 @@
+package summon
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+//capabilities of the server for the file, found by probing the url before downloading
+type capabilities struct {
+	size         int64       //-1 when the server did not send it
+	ranges       bool        //server supports range requests
+	filename     string      //from Content-Disposition, empty if not sent
+	etag         string      //ETag of the file, empty if not sent
+	lastModified string      //Last-Modified of the file, empty if not sent
+	contentType  string      //Content-Type of the file, empty if not sent
+	finalURL     string      //url after following the redirects
+	headers      http.Header //headers of the response, used for the server checksum
+}
+
+//probe finds the capabilities of the server using HEAD, GET with the first byte is used when HEAD fails or does not tell everything.
+//The body is not read so every request is limited by the connect and response timeouts of the transport
+func (sum *Downloader) probe(ctx context.Context) (*capabilities, error) {
+
+	caps, err := sum.probeWith(ctx, http.MethodHead)
+	switch {
+	case err != nil:
+		sum.debug.Printf("HEAD request failed : %v, trying GET", err)
+	case caps.size < 0:
+		sum.debug.Printf("HEAD response does not have the size, trying GET")
+	case !caps.ranges && caps.headers.Get("Accept-Ranges") == "":
+		sum.debug.Printf("HEAD response does not tell if ranges are supported, trying GET")
+	default:
+		return caps, nil
+	}
+
+	//the method passed by the user is only used for downloading, it may not be safe to repeat
+	getCaps, getErr := sum.probeWith(ctx, http.MethodGet)
+	if getErr != nil {
+		//HEAD has worked so use what it has told
+		if err == nil {
+			sum.debug.Printf("GET request failed : %v", getErr)
+			return caps, nil
+		}
+		return nil, getErr
+	}
+
+	return getCaps, nil
+}
+
+//probeWith sends the request for the first byte of the file using the method and reads the capabilities from the response
+func (sum *Downloader) probeWith(ctx context.Context, method string) (*capabilities, error) {
+
+	request, err := sum.newRequest(ctx, method, sum.uri)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating request : %v", err)
+	}
+
+	//HEAD does not need it but some servers only send Content-Range for ranged requests
+	request.Header.Set("Range", "bytes=0-0")
+
+	response, err := sum.doAPICall(request)
+	if err != nil {
+		return nil, err
+	}
+
+	//the body is not needed, the connection is not reused if the server sends the whole file
+	response.Body.Close()
+
+	sum.protocol = response.Proto
+
+	caps := &capabilities{
+		size:         -1,
+		etag:         response.Header.Get("ETag"),
+		lastModified: response.Header.Get("Last-Modified"),
+		contentType:  response.Header.Get("Content-Type"),
+		finalURL:     response.Request.URL.String(),
+		headers:      response.Header,
+		filename:     sum.filenameFromDisposition(response.Header.Get("Content-Disposition")),
+	}
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		caps.ranges = true
+		caps.size, err = parseContentRangeSize(response.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		//Content-MD5 of a partial response is of the part only
+		caps.headers.Del("Content-MD5")
+	case http.StatusOK:
+		caps.size = response.ContentLength
+		caps.ranges = method == http.MethodHead && response.Header.Get("Accept-Ranges") == "bytes"
+	case http.StatusRequestedRangeNotSatisfiable:
+		//the first byte does not exist so the file is empty, Content-Range is bytes */0
+		size, err := parseContentRangeSize(response.Header.Get("Content-Range"))
+		if err != nil || size != 0 {
+			return nil, statusError(response.StatusCode)
+		}
+		caps.size = 0
+	default:
+		return nil, statusError(response.StatusCode)
+	}
+
+	return caps, nil
+}
+
+//ifRange returns the value of the If-Range header so that the server sends the whole file instead of the range if it has changed.
+//Weak ETags cannot be used for it, Last-Modified is used instead
+func (c *capabilities) ifRange() string {
+
+	if c == nil {
+		return ""
+	}
+
+	if c.etag != "" && !strings.HasPrefix(c.etag, "W/") {
+		return c.etag
+	}
+
+	return c.lastModified
+}
+
+//parseContentRangeSize returns the size of the file in the Content-Range header, -1 if the size is not known.
+//Format is bytes 0-0/1234 or bytes */1234 for the 416 responses
+func parseContentRangeSize(cr string) (int64, error) {
+
+	_, _, total, err := parseContentRange(cr)
+
+	return total, err
+}
+
+//filenameFromDisposition returns the filename in the Content-Disposition header, empty if it is not there
+func (sum *Downloader) filenameFromDisposition(cd string) string {
+
+	if cd == "" {
+		return ""
+	}
+
+	_, params, err := mime.ParseMediaType(cd)
+	if err != nil {
+		sum.debug.Printf("error while parsing Content-Disposition %q : %v", cd, err)
+		return ""
+	}
+
+	//only the name is used, the server should not decide the directory
+	name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+
+	return name
+}
+
+//fileNameFromURL returns the last part of the path of the url
+func fileNameFromURL(u string) string {
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return path.Base(u)
+	}
+
+	name := path.Base(parsed.Path)
+	if name == "." || name == "/" {
+		return "index.html"
+	}
+
+	return name
+}
+
+//urlWithoutUser removes the credentials of the url so that it can be saved
+func urlWithoutUser(u string) string {
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+
+	parsed.User = nil
+
+	return parsed.String()
+}
+
+//redactURL removes the credentials and the query of the url as the query can have tokens, for logging
+func redactURL(u string) string {
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+
+	parsed.User = nil
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+
+	return parsed.String()
+}
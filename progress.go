@@ -1,55 +1,49 @@
-package main
+package summon
 
 import (
-	"fmt"
-	"math"
-	"strings"
 	"sync"
 	"time"
 )
 
-type progressBar struct {
-	p map[int64]*progress
-	*sync.RWMutex
+//Progress is the progress of a single segment of the file
+type Progress struct {
+	Index      int64 //Index of the segment starting from 0
+	Connection int64 //Connection downloading the segment starting from 1, 0 when none is
+	Start      int64 //Start is the first byte of the segment
+	Current    int64 //Current is the bytes read till now
+	Total      int64 //Total bytes which we are supposed to read, it is reduced when another connection takes over a part of the segment. -1 when the size of the file is not known
 }
 
-type progress struct {
-	curr  int64 //curr is the current read till now
-	total int64 //total bytes which we are supposed to read
+//ProgressSink receives the progress of all the segments, Update is called every second while downloading
+//and Done is called once with the final progress after all the connections have stopped
+type ProgressSink interface {
+	Update(p []Progress)
+	Done(p []Progress)
 }
 
-var progressSize int
-
-func (sum *summon) startProgressBar(wg *sync.WaitGroup, stop chan struct{}) {
+func (sum *Downloader) startProgressBar(wg *sync.WaitGroup, stop chan struct{}) {
 
 	defer wg.Done()
 
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
+	checkpoint := time.Now()
+
 	for {
 		select {
-		case <-ticker.C:
-			for i := int64(0); i < int64(len(sum.progressBar.p)); i++ {
-
-				sum.progressBar.RLock()
-				p := *sum.progressBar.p[i]
-				sum.progressBar.RUnlock()
-
-				printProgress(i, p)
+		case now := <-ticker.C:
+			if sum.progressSink != nil {
+				sum.progressSink.Update(sum.getProgress())
 			}
-
-			//Move cursor back
-			for i := 0; i < len(sum.progressBar.p); i++ {
-				fmt.Print("\033[F")
+			if now.Sub(checkpoint) >= CHECKPOINT_INTERVAL {
+				sum.saveMeta()
+				checkpoint = now
 			}
 
 		case <-stop:
-			for i := int64(0); i < int64(len(sum.progressBar.p)); i++ {
-				sum.progressBar.RLock()
-				p := *sum.progressBar.p[i]
-				sum.progressBar.RUnlock()
-				printProgress(i, p)
+			if sum.progressSink != nil {
+				sum.progressSink.Done(sum.getProgress())
 			}
 			return
 		}
@@ -57,24 +51,22 @@ func (sum *summon) startProgressBar(wg *sync.WaitGroup, stop chan struct{}) {
 
 }
 
-func printProgress(index int64, p progress) {
-
-	s := strings.Builder{}
-
-	percent := math.Round((float64(p.curr) / float64(p.total)) * 100)
+//getProgress returns the progress of all the segments ordered by index
+func (sum *Downloader) getProgress() []Progress {
 
-	n := int((percent / 100) * float64(progressSize))
+	sum.scheduler.Lock()
+	defer sum.scheduler.Unlock()
 
-	s.WriteString("[")
-	for i := 0; i < progressSize; i++ {
-		if i <= n {
-			s.WriteString(">")
-		} else {
-			s.WriteString(" ")
+	ps := make([]Progress, 0, len(sum.scheduler.segments))
+	for _, seg := range sum.scheduler.segments {
+		seg.Lock()
+		total := seg.end - seg.start + 1
+		if seg.end == UNKNOWN_END {
+			total = -1
 		}
+		ps = append(ps, Progress{Index: seg.index, Connection: seg.conn, Start: seg.start, Current: seg.done, Total: total})
+		seg.Unlock()
 	}
-	s.WriteString("]")
-	s.WriteString(fmt.Sprintf(" %v%%", percent))
 
-	fmt.Printf("Connection %d  - %s\n", index+1, s.String())
+	return ps
 }
@@ -0,0 +1,158 @@
+package summon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+//parseProxy parses the proxy url, http is used when the scheme is missing
+func parseProxy(p string) (*url.URL, error) {
+
+	if !strings.Contains(p, "://") {
+		p = "http://" + p
+	}
+
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url : %v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	case "socks5h":
+		//host names are always resolved by the socks5 proxy
+		u.Scheme = "socks5"
+	default:
+		return nil, fmt.Errorf("proxy scheme should be http, https or socks5, got : %v", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy host is missing in : %v", u.Redacted())
+	}
+
+	return u, nil
+}
+
+//proxyFor returns the proxy for the request, nil means the request is not proxied.
+//Without a proxy passed by the user the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used
+func (sum *Downloader) proxyFor(request *http.Request) (*url.URL, error) {
+
+	if sum.proxy == nil {
+		return http.ProxyFromEnvironment(request)
+	}
+
+	if matchNoProxy(sum.noProxy, request.URL) {
+		return nil, nil
+	}
+
+	return sum.proxy, nil
+}
+
+//matchNoProxy tells if the host of the url is in the comma separated list of hosts, domains, IPs and CIDRs
+func matchNoProxy(noProxy string, u *url.URL) bool {
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+
+	for _, entry := range strings.Split(noProxy, ",") {
+
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if entry == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip := net.ParseIP(host); ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		//an entry with a port only matches that port
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//defaultNoProxy returns the hosts in the NO_PROXY environment variable
+func defaultNoProxy() string {
+
+	if v := os.Getenv("NO_PROXY"); v != "" {
+		return v
+	}
+
+	return os.Getenv("no_proxy")
+}
+
+//proxyError makes the errors of the proxy clear, other errors are returned as is
+func (sum *Downloader) proxyError(request *http.Request, err error) error {
+
+	proxy, perr := sum.proxyFor(request)
+	if perr != nil || proxy == nil {
+		return err
+	}
+
+	p := proxy.Redacted()
+
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		return err
+	}
+
+	var oe *net.OpError
+	switch {
+	case errors.As(ue.Err, &oe) && oe.Op == "proxyconnect":
+		return fmt.Errorf("could not connect to proxy %v : %w", p, oe.Err)
+	case errors.As(ue.Err, &oe) && strings.HasPrefix(oe.Op, "socks"):
+		//the dialer of the transport uses the socks command as the op, the error is from the proxy
+		return fmt.Errorf("socks5 proxy %v could not connect to %v : %w", p, request.URL.Host, oe.Err)
+	case request.URL.Scheme == "https" && isConnectRefused(ue.Err):
+		return fmt.Errorf("proxy %v refused to connect to %v : %w", p, request.URL.Host, ue.Err)
+	}
+
+	return err
+}
+
+//connectRefusedError is returned when the proxy does not accept the CONNECT request
+type connectRefusedError struct {
+	status string //status of the response of the proxy, for example 403 Forbidden
+}
+
+func (e *connectRefusedError) Error() string {
+	return e.status
+}
+
+//proxyAuthError returns the error for the 407 response of the proxy, nil for other responses
+func (sum *Downloader) proxyAuthError(request *http.Request, response *http.Response) error {
+
+	if response.StatusCode != http.StatusProxyAuthRequired {
+		return nil
+	}
+
+	proxy, err := sum.proxyFor(request)
+	if err != nil || proxy == nil {
+		return fmt.Errorf("got proxy authentication required response without using a proxy")
+	}
+
+	return fmt.Errorf("proxy %v : %v, pass the credentials in the proxy url", proxy.Redacted(), response.Status)
+}
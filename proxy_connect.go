@@ -0,0 +1,27 @@
+//go:build go1.20
+// +build go1.20
+
+package summon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+//onConnectResponse makes the transport return a connectRefusedError when the proxy does not accept the CONNECT request
+func onConnectResponse(transport *http.Transport) {
+	transport.OnProxyConnectResponse = func(ctx context.Context, proxy *url.URL, request *http.Request, response *http.Response) error {
+		if response.StatusCode != http.StatusOK {
+			return &connectRefusedError{status: response.Status}
+		}
+		return nil
+	}
+}
+
+//isConnectRefused tells if the proxy did not accept the CONNECT request
+func isConnectRefused(err error) bool {
+	var ce *connectRefusedError
+	return errors.As(err, &ce)
+}
@@ -0,0 +1,23 @@
+//go:build !go1.20
+// +build !go1.20
+
+package summon
+
+import (
+	"net/http"
+)
+
+//onConnectResponse does nothing as the response of the CONNECT request cannot be checked before go 1.20
+func onConnectResponse(transport *http.Transport) {}
+
+//isConnectRefused tells if the proxy did not accept the CONNECT request, the transport returns only the text of the status then
+func isConnectRefused(err error) bool {
+
+	for code := 100; code < 600; code++ {
+		if http.StatusText(code) == err.Error() {
+			return true
+		}
+	}
+
+	return false
+}
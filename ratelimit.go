@@ -0,0 +1,127 @@
+package summon
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//RateLimiter is a token bucket which limits the bytes read per second, it can be shared by many downloads and the rate can be changed while downloading
+type RateLimiter struct {
+	rate   int64     //bytes per second, 0 means unlimited
+	tokens float64   //bytes which can be read right now, negative when the readers are waiting
+	last   time.Time //when the tokens were refilled last
+	sync.Mutex
+}
+
+//NewRateLimiter returns a limiter for bytes per second, 0 means unlimited
+func NewRateLimiter(bps int64) *RateLimiter {
+	return &RateLimiter{rate: bps, last: time.Now()}
+}
+
+//SetRate changes the rate in bytes per second, 0 means unlimited. It is safe to call while downloading
+func (rl *RateLimiter) SetRate(bps int64) {
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.refill()
+	rl.rate = bps
+
+	//do not keep the readers waiting for the debt made with the old rate
+	if rl.tokens < 0 {
+		rl.tokens = 0
+	}
+}
+
+//Rate returns the current rate in bytes per second, 0 means unlimited
+func (rl *RateLimiter) Rate() int64 {
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	return rl.rate
+}
+
+//refill adds the tokens for the time passed since the last refill, at most one second worth of tokens are kept. Caller should hold the lock
+func (rl *RateLimiter) refill() {
+
+	now := time.Now()
+	elapsed := now.Sub(rl.last)
+	rl.last = now
+
+	if rl.rate <= 0 {
+		rl.tokens = 0
+		return
+	}
+
+	rl.tokens += elapsed.Seconds() * float64(rl.rate)
+	if burst := float64(rl.rate); rl.tokens > burst {
+		rl.tokens = burst
+	}
+}
+
+//wait takes n tokens and blocks until they are available, it is safe to call on nil
+func (rl *RateLimiter) wait(ctx context.Context, n int) error {
+
+	if rl == nil || n <= 0 {
+		return nil
+	}
+
+	rl.Lock()
+	rl.refill()
+
+	if rl.rate <= 0 {
+		rl.Unlock()
+		return nil
+	}
+
+	rl.tokens -= float64(n)
+	wait := time.Duration(-rl.tokens / float64(rl.rate) * float64(time.Second))
+	rl.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	return sleepContext(ctx, wait)
+}
+
+//throttle waits as per the overall and the per connection rate after the bytes are read
+func (sum *Downloader) throttle(ctx context.Context, seg *segment, n int) error {
+
+	if err := sum.limiter.wait(ctx, n); err != nil {
+		return err
+	}
+
+	seg.Lock()
+	conn := seg.conn
+	seg.Unlock()
+
+	return sum.connLimiters[conn].wait(ctx, n)
+}
+
+//ParseRate parses rates like 500K, 5M or 1.5G into bytes per second, units are powers of 1024 and a plain number is bytes
+func ParseRate(rate string) (int64, error) {
+
+	s := strings.TrimSpace(strings.ToUpper(rate))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "B")
+
+	multiplier := float64(1)
+	if s != "" {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			multiplier = float64(int64(1) << (10 * uint(i+1)))
+			s = s[:len(s)-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate : %v, use values like 500K, 5M or 1G", rate)
+	}
+
+	return int64(v * multiplier), nil
+}
@@ -0,0 +1,112 @@
+package summon
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+
+	tests := map[string]struct {
+		rate string
+		want int64
+		ok   bool
+	}{
+		"bytes":        {rate: "1000", want: 1000, ok: true},
+		"zero":         {rate: "0", want: 0, ok: true},
+		"kilo":         {rate: "500K", want: 500 * 1024, ok: true},
+		"lower case":   {rate: "500k", want: 500 * 1024, ok: true},
+		"mega":         {rate: "5M", want: 5 * MiB, ok: true},
+		"fraction":     {rate: "1.5M", want: 3 * MiB / 2, ok: true},
+		"giga":         {rate: "1G", want: GiB, ok: true},
+		"with b":       {rate: "2MB", want: 2 * MiB, ok: true},
+		"per second":   {rate: "2MB/s", want: 2 * MiB, ok: true},
+		"spaces":       {rate: " 10K ", want: 10 * 1024, ok: true},
+		"empty":        {rate: ""},
+		"only unit":    {rate: "M"},
+		"negative":     {rate: "-1M"},
+		"unknown unit": {rate: "5X"},
+		"two units":    {rate: "5KM"},
+		"not a number": {rate: "fast"},
+	}
+
+	for name, tt := range tests {
+
+		got, err := ParseRate(tt.rate)
+
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%s : ParseRate(%q) = %v, %v, want %v", name, tt.rate, got, err, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+
+	rate := 4 * MiB
+	rl := NewRateLimiter(rate)
+
+	start := time.Now()
+	for read := int64(0); read < MiB; read += 32 * 1024 {
+		if err := rl.wait(context.Background(), 32*1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the bucket starts empty so 1 MiB takes a quarter of a second
+	if took := time.Since(start); took < 200*time.Millisecond || took > time.Second {
+		t.Fatalf("1 MiB at 4 MiB/s took %v", took)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := rl.wait(ctx, int(rate)); err == nil {
+		t.Fatal("wait did not return when the context was cancelled")
+	}
+}
+
+//TestRateLimit checks that a download is not faster than the limit and that a new rate is used while it is downloading
+func TestRateLimit(t *testing.T) {
+
+	size := MiB
+	srv := newRangeServer(t, size, true)
+
+	run := func(opts ...Option) time.Duration {
+
+		t.Helper()
+
+		sum, err := New(srv.URL+"/file.bin", append([]Option{WithOutput(filepath.Join(t.TempDir(), "file.bin")), WithConnections(4)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		if err := sum.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		return time.Since(start)
+	}
+
+	//1 MiB at 2 MiB/s for all the connections together
+	if took := run(WithRateLimit(2 * MiB)); took < 400*time.Millisecond {
+		t.Fatalf("1 MiB at 2 MiB/s took %v", took)
+	}
+
+	//it would take 8 seconds at the first rate
+	rl := NewRateLimiter(128 * 1024)
+	timer := time.AfterFunc(300*time.Millisecond, func() {
+		rl.SetRate(0)
+	})
+	defer timer.Stop()
+
+	if took := run(WithRateLimiter(rl)); took < 300*time.Millisecond || took > 4*time.Second {
+		t.Fatalf("download took %v, want the new rate to be used once it is set after 300ms", took)
+	}
+
+	if rl.Rate() != 0 {
+		t.Fatalf("rate is %d, want 0", rl.Rate())
+	}
+}
@@ -0,0 +1,126 @@
+package summon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//newRequest creates the request with the headers, cookies and credentials passed by the user, every request to the server should be created using this
+func (sum *Downloader) newRequest(ctx context.Context, method, u string) (*http.Request, error) {
+
+	request, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range sum.headers {
+		for _, v := range values {
+			request.Header.Add(name, v)
+		}
+	}
+
+	//Host cannot be set using the headers
+	if host := sum.headers.Get("Host"); host != "" {
+		request.Host = host
+	}
+
+	if err := sum.setAuthorization(request); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+//parseHeader parses a header in "Name: value" format
+func parseHeader(h string) (string, string, error) {
+
+	kv := strings.SplitN(h, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", "", fmt.Errorf("header should be in \"Name: value\" format, got : %v", h)
+	}
+
+	return strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]), nil
+}
+
+//loadCookieFile reads the cookies from a Netscape cookies.txt file as written by curl and the browsers
+func loadCookieFile(path string, jar http.CookieJar) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error while opening cookie file : %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		//domain, include subdomains, path, secure, expiry, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("cookie file line %d : expected 7 tab separated fields, got %d", lineNo, len(fields))
+		}
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cookie file line %d : invalid expiry : %v", lineNo, fields[4])
+		}
+
+		host := strings.TrimPrefix(fields[0], ".")
+		secure := strings.EqualFold(fields[3], "TRUE")
+
+		cookie := &http.Cookie{Name: fields[5], Value: fields[6], Path: fields[2], Secure: secure, HttpOnly: httpOnly}
+
+		//host only cookies do not have the domain attribute
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+
+		//0 is a session cookie
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: fields[2]}, []*http.Cookie{cookie})
+	}
+
+	return scanner.Err()
+}
+
+//getCookieJar returns the cookie jar creating it if needed
+func (sum *Downloader) getCookieJar() (http.CookieJar, error) {
+
+	if sum.cookieJar != nil {
+		return sum.cookieJar, nil
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sum.cookieJar = jar
+
+	return jar, nil
+}
@@ -0,0 +1,194 @@
+package summon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+
+	tests := map[string]struct {
+		header      string
+		name, value string
+		ok          bool
+	}{
+		"simple":         {header: "Accept: */*", name: "Accept", value: "*/*", ok: true},
+		"no space":       {header: "X-Trace:1", name: "X-Trace", value: "1", ok: true},
+		"colon in value": {header: "Authorization: Bearer a:b", name: "Authorization", value: "Bearer a:b", ok: true},
+		"spaces":         {header: "  X-A  :  b  ", name: "X-A", value: "b", ok: true},
+		"empty value":    {header: "X-Empty:", name: "X-Empty", ok: true},
+		"no colon":       {header: "Accept */*"},
+		"no name":        {header: ": value"},
+		"empty":          {header: ""},
+	}
+
+	for name, tt := range tests {
+
+		n, v, err := parseHeader(tt.header)
+
+		if (err == nil) != tt.ok || n != tt.name || v != tt.value {
+			t.Errorf("%s : parseHeader(%q) = %q, %q, %v, want %q, %q", name, tt.header, n, v, err, tt.name, tt.value)
+		}
+	}
+}
+
+func TestLoadCookieFile(t *testing.T) {
+
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	cookies := fmt.Sprintf(`# Netscape HTTP Cookie File
+# comment
+
+.example.com	TRUE	/	FALSE	%d	domain	1
+example.com	FALSE	/	FALSE	0	host	2
+example.com	FALSE	/dl	FALSE	%d	path	3
+example.com	FALSE	/	TRUE	%d	secure	4
+#HttpOnly_example.com	FALSE	/	FALSE	%d	httponly	5
+example.com	FALSE	/	FALSE	%d	expired	6
+`, future, future, future, future, past)
+
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(path, []byte(cookies), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	if err := loadCookieFile(path, jar); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "http://example.com/", want: "domain host httponly"},
+		{url: "http://example.com/dl/file.bin", want: "domain host httponly path"},
+		{url: "https://example.com/", want: "domain host httponly secure"},
+		{url: "http://cdn.example.com/", want: "domain"},
+		{url: "http://other.com/", want: ""},
+	}
+
+	for _, tt := range tests {
+
+		u, _ := url.Parse(tt.url)
+
+		var names []string
+		for _, c := range jar.Cookies(u) {
+			names = append(names, c.Name)
+		}
+		sort.Strings(names)
+
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("cookies for %v are %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCookieFileErrors(t *testing.T) {
+
+	tests := map[string]string{
+		"spaces":         "example.com FALSE / FALSE 0 name value",
+		"missing fields": "example.com\tFALSE\t/\tFALSE\t0\tname",
+		"invalid expiry": "example.com\tFALSE\t/\tFALSE\tnever\tname\tvalue",
+	}
+
+	for name, content := range tests {
+
+		path := filepath.Join(t.TempDir(), "cookies.txt")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		jar, _ := cookiejar.New(nil)
+		if err := loadCookieFile(path, jar); err == nil {
+			t.Errorf("%s : loadCookieFile() did not fail", name)
+		}
+	}
+
+	jar, _ := cookiejar.New(nil)
+	if err := loadCookieFile(filepath.Join(t.TempDir(), "missing"), jar); err == nil {
+		t.Error("loadCookieFile() of a missing file did not fail")
+	}
+}
+
+//TestRequestHeaders checks that the user agent, referer, headers and cookies are sent with every request and the method with the downloads
+func TestRequestHeaders(t *testing.T) {
+
+	size := 2 * MiB
+
+	var mu sync.Mutex
+	var got []*http.Request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r)
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+	}))
+	defer srv.Close()
+
+	cookies := "127.0.0.1\tFALSE\t/dl\tFALSE\t0\tsession\tabc\n127.0.0.1\tFALSE\t/other\tFALSE\t0\tother\txyz\n"
+
+	cookieFile := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(cookieFile, []byte(cookies), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := New(srv.URL+"/dl/file.bin",
+		WithOutput(filepath.Join(t.TempDir(), "file.bin")),
+		WithConnections(2),
+		WithMethod("post"),
+		WithUserAgent("summon-test/1.0"),
+		WithReferer("https://example.com/page"),
+		WithRawHeader("X-Trace: 42"),
+		WithCookieFile(cookieFile),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var downloads int
+
+	for _, r := range got {
+
+		if r.UserAgent() != "summon-test/1.0" || r.Referer() != "https://example.com/page" || r.Header.Get("X-Trace") != "42" {
+			t.Errorf("%v request has user agent %q, referer %q, X-Trace %q", r.Method, r.UserAgent(), r.Referer(), r.Header.Get("X-Trace"))
+		}
+
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			t.Errorf("%v request has cookies %q, want the session cookie", r.Method, r.Header.Get("Cookie"))
+		}
+
+		if _, err := r.Cookie("other"); err == nil {
+			t.Errorf("cookie of another path is sent with the %v request", r.Method)
+		}
+
+		switch r.Method {
+		case http.MethodHead:
+		case http.MethodPost:
+			downloads++
+		default:
+			t.Errorf("file is downloaded with %v, want POST", r.Method)
+		}
+	}
+
+	if downloads < 2 {
+		t.Fatalf("%d requests downloaded the file, want at least 2", downloads)
+	}
+}
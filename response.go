@@ -0,0 +1,120 @@
+package summon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//ErrRemoteChanged is returned by Run when the file on the server is not the same as the one which was being downloaded
+var ErrRemoteChanged = errors.New("file on the server has changed")
+
+//errRangeIgnored is returned when the server sends the whole file for a range request, the file is then downloaded again over one connection
+var errRangeIgnored = errors.New("server ignored the range request and sent the whole file")
+
+//checkRangeResponse verifies that the response is for the range start-end which was asked, end is UNKNOWN_END when the size is not known.
+//ranged tells if the Range header was sent, the whole file is asked without it
+func (sum *Downloader) checkRangeResponse(response *http.Response, start, end int64, ranged bool) error {
+
+	size := sum.fileDetails.contentLength
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		//asking for the range from the first till the last byte is same as asking for the whole file
+		if ranged && (start > 0 || end != size-1) {
+			return sum.rangeNotSent(response)
+		}
+		if size >= 0 && response.ContentLength >= 0 && response.ContentLength != size {
+			return fmt.Errorf("server sent %d bytes but the size of the file is %d", response.ContentLength, size)
+		}
+	case http.StatusPartialContent:
+		if !ranged {
+			return fmt.Errorf("server sent partial content without a range request")
+		}
+
+		cr := response.Header.Get("Content-Range")
+		first, last, total, err := parseContentRange(cr)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case first != start || (end != UNKNOWN_END && last != end):
+			return fmt.Errorf("server sent Content-Range %q for the range %d-%d", cr, start, end)
+		case last < first:
+			return fmt.Errorf("server sent the invalid Content-Range %q", cr)
+		case size >= 0 && total >= 0 && total != size:
+			return fmt.Errorf("server sent Content-Range %q but the size of the file is %d, the file has changed", cr, size)
+		case response.ContentLength >= 0 && response.ContentLength != last-first+1:
+			return fmt.Errorf("server sent %d bytes for Content-Range %q", response.ContentLength, cr)
+		}
+	default:
+		return statusError(response.StatusCode)
+	}
+
+	return nil
+}
+
+//rangeNotSent tells why the server sent the whole file for the range request, with If-Range it is sent when the file has changed
+func (sum *Downloader) rangeNotSent(response *http.Response) error {
+
+	if response.Request.Header.Get("If-Range") == "" {
+		return errRangeIgnored
+	}
+
+	caps := sum.caps
+
+	if etag := response.Header.Get("ETag"); caps.etag != "" && etag != "" && etag != caps.etag {
+		return fmt.Errorf("%w while downloading, ETag was %s and now it is %s", ErrRemoteChanged, caps.etag, etag)
+	}
+
+	if lm := response.Header.Get("Last-Modified"); caps.lastModified != "" && lm != "" && lm != caps.lastModified {
+		return fmt.Errorf("%w while downloading, it was modified at %s and now at %s", ErrRemoteChanged, caps.lastModified, lm)
+	}
+
+	return errRangeIgnored
+}
+
+//parseContentRange returns the first byte, the last byte and the size of the file in the Content-Range header.
+//Format is bytes 0-1023/1234, the size is -1 if it is *, first and last are -1 for bytes */1234 which is sent with 416
+func parseContentRange(cr string) (first, last, total int64, err error) {
+
+	invalid := fmt.Errorf("invalid Content-Range : %q", cr)
+
+	if !strings.HasPrefix(cr, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+
+	slash := strings.LastIndexByte(cr, '/')
+	if slash < 0 {
+		return 0, 0, 0, invalid
+	}
+
+	first, last, total = -1, -1, -1
+
+	if t := cr[slash+1:]; t != "*" {
+		size, err := parseint64(t)
+		if err != nil {
+			return 0, 0, 0, invalid
+		}
+		total = size[0]
+	}
+
+	r := strings.TrimSpace(cr[len("bytes "):slash])
+	if r == "*" {
+		return first, last, total, nil
+	}
+
+	dash := strings.IndexByte(r, '-')
+	if dash < 0 {
+		return 0, 0, 0, invalid
+	}
+
+	pos, err := parseint64(r[:dash], r[dash+1:])
+	if err != nil {
+		return 0, 0, 0, invalid
+	}
+
+	return pos[0], pos[1], total, nil
+}
@@ -1,13 +1,48 @@
-package main
+package summon
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+//ResumePolicy decides what is done with an incomplete download of the same file which is found before downloading
+type ResumePolicy int
+
+const (
+	RESUME_AUTO   ResumePolicy = iota + 1 //resume if the file on the server has not changed, otherwise download again
+	RESUME_ALWAYS                         //resume, fail if the file on the server has changed
+	RESUME_NEVER                          //delete the incomplete download and download again
+	RESUME_ASK                            //resume if the function set by WithResumeConfirm agrees, same as auto without it
+)
+
+var resumePolicies = map[ResumePolicy]string{
+	RESUME_AUTO:   "auto",
+	RESUME_ALWAYS: "always",
+	RESUME_NEVER:  "never",
+	RESUME_ASK:    "ask",
+}
+
+func (rp ResumePolicy) String() string {
+	return resumePolicies[rp]
+}
+
+//ParseResumePolicy parses the name of the policy which can be auto, always, never or ask
+func ParseResumePolicy(name string) (ResumePolicy, error) {
+
+	for rp, n := range resumePolicies {
+		if strings.EqualFold(name, n) {
+			return rp, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid resume policy : %q, it can be auto, always, never or ask", name)
+}
+
 type resume struct {
 	downloaded   int64
 	start        int64
@@ -15,42 +50,25 @@ type resume struct {
 	tempFilePath string
 }
 
-type meta struct {
-	ChunkPaths map[int64]string  `json:"chunkPaths"` //Key is index & value is absolute path of chunk
-	Range      map[int64][]int64 `json:"range"`      //Key is index & value is the initial range which was used. 0 being start and 1 being the end
-}
-
-//getMetaData will set the meta data to summon
-func (sum *summon) setMetaData(fpath string) error {
-
-	fname := sum.getMetaFileName()
-	meta := meta{}
+//setMetaData reads the meta file of the incomplete download
+func (sum *Downloader) setMetaData() error {
 
-	data, err := os.ReadFile(fname)
+	m, err := readMeta(sum.getMetaFileName())
 	if err != nil {
 		return err
 	}
 
-	decoded, err := decode(data)
-	if err != nil {
-		return err
-	}
-
-	if err := json.Unmarshal(decoded, &meta); err != nil {
-		return err
-	}
-
-	sum.metaData = meta
+	sum.metaData = m
 
 	return nil
 }
 
-func (sum summon) getMetaFileName() string {
+func (sum *Downloader) getMetaFileName() string {
 	return sum.fileDetails.fileDir + sum.separator + "." + sum.fileDetails.fileName + ".summon.meta"
 }
 
 //canBeResumed tells us if the file can be resumed
-func (sum *summon) canBeResumed(fpath string) (bool, []string) {
+func (sum *Downloader) canBeResumed(fpath string) (bool, []string) {
 
 	//If meta file does not exist we cant resume the download
 	if !fileExists(sum.getMetaFileName()) {
@@ -58,149 +76,423 @@ func (sum *summon) canBeResumed(fpath string) (bool, []string) {
 	}
 
 	//Set the metadata
-	if err := sum.setMetaData(fpath); err != nil {
+	if err := sum.setMetaData(); err != nil {
+		sum.log.Printf("Cannot resume the incomplete download : %v", err)
 		return false, []string{}
 	}
 
 	parts := []string{}
 
-	for index, filePath := range sum.metaData.ChunkPaths {
+	//the size is known only now for the meta files of the first versions
+	if sum.metaData.Size < 0 {
+		sum.metaData.fixLegacyEnd(sum.caps.size)
+	}
+
+	if sum.metaData.Direct {
+		return sum.canBeResumedDirect(fpath), parts
+	}
+
+	parts = sum.metaData.paths()
 
-		start, end := sum.metaData.Range[index][0], sum.metaData.Range[index][1]
+	for index, c := range sum.metaData.Chunks {
 
-		finfo, err := os.Stat(filePath)
+		finfo, err := os.Stat(c.Path)
 		if err != nil {
-			LogWriter.Println(err)
+			sum.debug.Println(err)
 			return false, parts
 		}
 
-		//This size is in bytes
-		contentL := finfo.Size()
+		//bytes written after the last checkpoint may not be on the disk, they are downloaded again
+		downloaded := finfo.Size()
+		if c.Done >= 0 && c.Done < downloaded {
+			downloaded = c.Done
+		}
 
-		sum.fileDetails.chunks[index] = nil
-		sum.fileDetails.resume[index] = resume{downloaded: int64(contentL), end: end, start: start, tempFilePath: filePath}
+		sum.fileDetails.chunks[int64(index)] = nil
+		sum.fileDetails.resume[int64(index)] = resume{downloaded: downloaded, end: c.End, start: c.Start, tempFilePath: c.Path}
 	}
 
 	return true, parts
 }
 
-func (sum *summon) resumeDownload(wg *sync.WaitGroup) error {
+//canBeResumedDirect tells us if the output file written directly by the connections can be resumed
+func (sum *Downloader) canBeResumedDirect(fpath string) bool {
 
-	for index := range sum.fileDetails.chunks {
+	if !fileExists(fpath) {
+		return false
+	}
 
-		var start, end, total int64
+	for index, c := range sum.metaData.Chunks {
+		if c.Done < 0 {
+			return false
+		}
+		sum.fileDetails.resume[int64(index)] = resume{downloaded: c.Done, start: c.Start, end: c.End, tempFilePath: fpath}
+	}
 
-		//The previous start range + the bytes we have downloaded will give us the new range
-		start = sum.fileDetails.resume[index].start + sum.fileDetails.resume[index].downloaded
+	return true
+}
 
-		//We will use the same end here as we are going to use same concurrency
-		end = sum.fileDetails.resume[index].end
+//shouldResume decides as per the resume policy if the incomplete download which was found is resumed
+func (sum *Downloader) shouldResume() (bool, error) {
 
-		//We need to use the old total value only
-		total = sum.fileDetails.resume[index].end - sum.fileDetails.resume[index].start
+	policy := sum.resumePolicy.String()
+	if sum.force {
+		policy += " (forced)"
+	}
 
-		//We will start the progress from last time, so we set the current progress directly
-		sum.progressBar.p[index] = &progress{curr: sum.fileDetails.resume[index].downloaded, total: total}
+	sum.log.Printf("Found an incomplete download, resume policy is %v", policy)
 
-		f, err := os.OpenFile(sum.fileDetails.resume[index].tempFilePath, os.O_RDWR|os.O_APPEND, 0644)
+	if sum.resumePolicy == RESUME_NEVER {
+		sum.log.Printf("Deleting the incomplete download and downloading again")
+		return false, nil
+	}
+
+	if err := sum.checkRemoteFile(); err != nil {
+		if sum.resumePolicy == RESUME_ALWAYS {
+			return false, fmt.Errorf("cannot resume the download : %w", err)
+		}
+		sum.log.Printf("Cannot resume the download, %v. Downloading again", err)
+		return false, nil
+	}
+
+	//checked after the file on the server as the ranges of a changed file do not match either, ranges which do not match
+	//an unchanged file are from a broken meta file so it is downloaded again with any policy
+	planner := newRangePlanner(sum.caps.size, sum.concurrency, sum.caps.ranges)
+	if err := planner.validate(sum.metaData.ranges(sum.caps.size)); err != nil {
+		sum.log.Printf("Cannot resume the download, ranges in meta file do not match the file : %v. Downloading again", err)
+		return false, nil
+	}
+
+	if sum.resumePolicy == RESUME_ASK && sum.confirmResume != nil {
+		ok, err := sum.confirmResume(sum.fileDetails.absolutePath)
 		if err != nil {
-			return err
+			return false, fmt.Errorf("error while asking to resume : %v", err)
 		}
+		if !ok {
+			sum.log.Printf("Deleting the incomplete download and downloading again")
+			return false, nil
+		}
+	}
 
-		//Set the file handles so that combine can use them
-		sum.fileDetails.chunks[index] = f
+	sum.log.Printf("Resuming the incomplete download")
 
-		//If chunk is already completed skip download
-		if start > sum.fileDetails.resume[index].end {
-			continue
+	return true, nil
+}
+
+//checkRemoteFile compares the file on the server with the one in the meta file, the error tells why the download cannot be resumed
+func (sum *Downloader) checkRemoteFile() error {
+
+	m, caps := sum.metaData, sum.caps
+
+	//meta files written by the older versions do not have the details of the file
+	if m.FinalURL == "" {
+		sum.debug.Printf("Meta file does not have the details of the file, cannot check if it has changed")
+		return nil
+	}
+
+	switch {
+	case m.ETag != "" && caps.etag != "" && m.ETag != caps.etag:
+		return fmt.Errorf("%w, ETag was %s and now it is %s", ErrRemoteChanged, m.ETag, caps.etag)
+	case m.LastModified != "" && caps.lastModified != "" && m.LastModified != caps.lastModified:
+		return fmt.Errorf("%w, it was modified at %s and now at %s", ErrRemoteChanged, m.LastModified, caps.lastModified)
+	case m.Size >= 0 && caps.size >= 0 && m.Size != caps.size:
+		return fmt.Errorf("%w, size was %d and now it is %d", ErrRemoteChanged, m.Size, caps.size)
+	}
+
+	validated := (m.ETag != "" && caps.etag != "") || (m.LastModified != "" && caps.lastModified != "")
+
+	//the meta files written before the url was saved are checked only by the file details
+	if passed := urlWithoutUser(sum.uri); m.URL != "" && m.URL != passed {
+		//a signed url with a new query is the same file only if the server can tell that it has not changed
+		if redactURL(m.URL) != redactURL(passed) || !validated {
+			return fmt.Errorf("it was started from another url : %v", redactURL(m.URL))
 		}
+		sum.debug.Printf("Query of the url is different than the last time, the file has not changed")
+	}
 
-		contentRange := fmt.Sprintf("%d-%d", start, end)
+	if !validated {
+		sum.log.Printf("Server does not send ETag or Last-Modified, cannot check if the file has changed since the last time")
+	}
 
-		wg.Add(1)
-		go sum.downloadFileForRange(wg, contentRange, index, f)
+	//signed urls are different every time so it is not a change of the file
+	if m.FinalURL != caps.finalURL {
+		sum.debug.Printf("Redirected to another url than the last time : %v", redactURL(caps.finalURL))
 	}
 
 	return nil
 }
 
-func (sum *summon) download(wg *sync.WaitGroup) error {
+func (sum *Downloader) resumeDownload(ctx context.Context, wg *sync.WaitGroup) error {
+
+	ranges := make([]byteRange, 0, len(sum.fileDetails.resume))
 
-	index := int64(0)
-	split := sum.fileDetails.contentLength / sum.concurrency
-	meta := meta{ChunkPaths: make(map[int64]string), Range: make(map[int64][]int64)}
+	for index := int64(0); index < int64(len(sum.fileDetails.resume)); index++ {
+
+		r, ok := sum.fileDetails.resume[index]
+		if !ok {
+			return fmt.Errorf("range for segment %d is missing in meta file", index)
+		}
 
-	for start := int64(0); start < sum.fileDetails.contentLength; start += split + 1 {
-		end := start + split
-		if end > sum.fileDetails.contentLength {
-			end = sum.fileDetails.contentLength
+		//size was not known when the download started but the server sends it now
+		if r.end == UNKNOWN_END && sum.fileDetails.contentLength >= 0 {
+			r.end = sum.fileDetails.contentLength - 1
+			sum.fileDetails.resume[index] = r
 		}
 
-		//get temp file name
-		partFileName, err := sum.getTempFileName(index, start, end)
+		ranges = append(ranges, byteRange{start: r.start, end: r.end})
+	}
+
+	planner := newRangePlanner(sum.fileDetails.contentLength, sum.concurrency, sum.isRangeSupported)
+	if err := planner.validate(ranges); err != nil {
+		return fmt.Errorf("ranges in meta file do not match the file : %v", err)
+	}
+
+	//segments are added in the order of their index as combining and the meta file depend on it
+	for index := int64(0); index < int64(len(ranges)); index++ {
+
+		r := sum.fileDetails.resume[index]
+
+		w, err := sum.openChunkWriter(index, r)
 		if err != nil {
 			return err
 		}
 
-		//Create temp file
-		f, err := os.Create(partFileName)
-		if err != nil {
+		//We will start the progress from last time, completed segments are skipped by the connections
+		sum.addSegment(r.start, r.end, r.downloaded, w)
+	}
+
+	if sum.isRangeSupported && sum.fileDetails.contentLength >= 0 {
+		if err := sum.replanSegments(planner); err != nil {
 			return err
 		}
+	}
 
-		//Set metadata
-		meta.ChunkPaths[index] = f.Name()
-		meta.Range[index] = []int64{start, end}
+	//continue the checksum from where it was instead of reading the file again at the end
+	if sum.checksum.restore(sum.metaData.Checksum, downloadedPrefix(sum.fileDetails.resume)) {
+		sum.debug.Printf("Checksum continues from %d bytes", sum.metaData.Checksum.Offset)
+	}
 
-		//init progressbar
-		sum.progressBar.p[index] = &progress{curr: 0, total: end - start}
+	sum.scheduler.split = sum.isRangeSupported && sum.fileDetails.contentLength >= 0
+	sum.startWorkers(ctx, wg)
 
-		//init temp files
-		sum.fileDetails.chunks[index] = f
+	return nil
+}
+
+//replanSegments cuts what is left of the resumed segments across the connections, the number of connections can be different
+//from the last time. A segment keeps its downloaded bytes and the first part of what is left, the rest become new segments
+func (sum *Downloader) replanSegments(planner rangePlanner) error {
 
-		contentRange := fmt.Sprintf("%d-%d", start, end)
+	sum.scheduler.Lock()
+	defer sum.scheduler.Unlock()
 
-		wg.Add(1)
-		go sum.downloadFileForRange(wg, contentRange, index, f)
-		index++
+	resumed := sum.scheduler.segments
+	remaining := make([]byteRange, 0, len(resumed))
+	pending := make([]*segment, 0, len(resumed))
+
+	for _, seg := range resumed {
+		if seg.remaining() > 0 {
+			remaining = append(remaining, byteRange{start: seg.start + seg.done, end: seg.end})
+			pending = append(pending, seg)
+		}
 	}
 
-	if !sum.isRangeSupported {
-		return nil
+	var added int
+
+	for i, pieces := range planner.split(remaining) {
+
+		seg := pending[i]
+		seg.end = pieces[0].end
+
+		for _, r := range pieces[1:] {
+
+			index := int64(len(sum.scheduler.segments))
+
+			w, err := sum.createChunkWriter(index, r.start, r.end)
+			if err != nil {
+				return err
+			}
+
+			sum.scheduler.segments = append(sum.scheduler.segments, &segment{index: index, start: r.start, end: r.end, w: w})
+			added++
+		}
 	}
 
-	sum.addMetadataToFile(meta)
+	ranges := make([]byteRange, 0, len(sum.scheduler.segments))
+	for _, seg := range sum.scheduler.segments {
+		ranges = append(ranges, byteRange{start: seg.start, end: seg.end})
+	}
+
+	if err := planner.validate(ranges); err != nil {
+		return fmt.Errorf("error while planning the remaining ranges : %v", err)
+	}
+
+	sum.debug.Printf("Remaining %d ranges are cut into %d for %d connections", len(remaining), len(remaining)+added, sum.concurrency)
+
+	//the new ranges need to be saved before downloading, otherwise the next resume would not know about them
+	if added > 0 {
+		sum.saveMetaLocked()
+	}
 
 	return nil
+}
+
+//downloadedPrefix returns the number of bytes from the start of the file which are downloaded without a gap
+func downloadedPrefix(resumes map[int64]resume) int64 {
+
+	ranges := make([]resume, 0, len(resumes))
+	for _, r := range resumes {
+		ranges = append(ranges, r)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
 
+	var prefix int64
+	for _, r := range ranges {
+		if r.start != prefix {
+			break
+		}
+		prefix = r.start + r.downloaded
+		if r.downloaded < r.end-r.start+1 {
+			break
+		}
+	}
+
+	return prefix
 }
 
-func (sum summon) addMetadataToFile(m meta) {
-	//Add metadata to file
-	metaFname := sum.getMetaFileName()
-	metaData, err := json.Marshal(m)
-	if err != nil {
-		LogWriter.Printf("Error occured while marshalling json : %v", err)
+func (sum *Downloader) download(ctx context.Context, wg *sync.WaitGroup) error {
+
+	if sum.direct && sum.fileDetails.contentLength >= 0 {
+		if err := preallocate(sum.fileDetails.tempOutFile, sum.fileDetails.contentLength); err != nil {
+			return fmt.Errorf("error while preallocating output file : %v", err)
+		}
+	}
+
+	planner := newRangePlanner(sum.fileDetails.contentLength, sum.concurrency, sum.isRangeSupported)
+	ranges := planner.plan()
+
+	if err := planner.validate(ranges); err != nil {
+		return fmt.Errorf("error while planning the ranges : %v", err)
+	}
+
+	for index, r := range ranges {
+
+		w, err := sum.createChunkWriter(int64(index), r.start, r.end)
+		if err != nil {
+			return err
+		}
+
+		sum.addSegment(r.start, r.end, 0, w)
+	}
+
+	sum.scheduler.split = sum.isRangeSupported && sum.fileDetails.contentLength >= 0
+	sum.saveMeta()
+	sum.startWorkers(ctx, wg)
+
+	return nil
+
+}
+
+//saveMeta writes the current segments and the bytes downloaded for every segment to the meta file
+func (sum *Downloader) saveMeta() {
+	sum.scheduler.Lock()
+	defer sum.scheduler.Unlock()
+	sum.saveMetaLocked()
+}
+
+//saveMetaLocked is same as saveMeta but the caller should hold the scheduler lock
+func (sum *Downloader) saveMetaLocked() {
+
+	//download cannot be resumed without range support, unless the size is not known as the server might support ranges later
+	if !sum.isRangeSupported && sum.fileDetails.contentLength >= 0 {
+		return
+	}
+
+	m := meta{
+		Version:     META_VERSION,
+		URL:         urlWithoutUser(sum.uri),
+		Size:        sum.fileDetails.contentLength,
+		Direct:      sum.direct,
+		Connections: sum.concurrency,
+		Chunks:      make([]metaChunk, 0, len(sum.scheduler.segments)),
+		Updated:     time.Now(),
+	}
+
+	if sum.caps != nil {
+		m.ETag, m.LastModified, m.FinalURL = sum.caps.etag, sum.caps.lastModified, urlWithoutUser(sum.caps.finalURL)
 	}
 
-	finalData := bytes.NewBuffer(nil)
-	if err := encode(metaData, finalData); err != nil {
-		LogWriter.Printf("Error occured while encoding meta data : %v", err)
+	//taken before the segments so that it does not have bytes which the segments do not count
+	m.Checksum = sum.checksum.state()
+
+	for _, seg := range sum.scheduler.segments {
+		c := metaChunk{}
+
+		seg.Lock()
+		c.Start, c.End, c.Done = seg.start, seg.end, seg.done
+		seg.Unlock()
+
+		if f, ok := sum.fileDetails.chunks[seg.index]; ok && f != nil {
+			c.Path = f.Name()
+		}
+
+		m.Chunks = append(m.Chunks, c)
 	}
 
-	if err := os.WriteFile(metaFname, finalData.Bytes(), 0644); err != nil {
-		LogWriter.Printf("Error occured while writing meta data : %v", err)
+	//the bytes counted in the meta file should be on the disk before it is written
+	if err := sum.syncChunks(); err != nil {
+		sum.debug.Printf("Error occured while syncing the downloaded data, keeping the last checkpoint : %v", err)
+		return
 	}
+
+	if err := writeMeta(sum.getMetaFileName(), m); err != nil {
+		sum.debug.Printf("Error occured while writing meta data : %v", err)
+		return
+	}
+
+	sum.metaData = m
+}
+
+//syncChunks flushes the downloaded bytes to the disk
+func (sum *Downloader) syncChunks() error {
+
+	if sum.direct {
+		return sum.fileDetails.tempOutFile.Sync()
+	}
+
+	for _, f := range sum.fileDetails.chunks {
+		if f == nil {
+			continue
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 //deleteFiles deletes the list of files provided
-func deleteFiles(chunks map[int64]*os.File, tempFileName ...string) error {
+func (sum *Downloader) deleteFiles(chunks map[int64]*os.File, tempFileName ...string) error {
+
+	for index, handle := range chunks {
 
-	for _, handle := range chunks {
-		if handle == nil {
+		name := ""
+		switch r, ok := sum.fileDetails.resume[index]; {
+		case handle != nil:
+			name = handle.Name()
+		case ok && !sum.direct:
+			//part files of a resumed download are opened only when the download starts
+			name = r.tempFilePath
+		}
+
+		if name == "" || !fileExists(name) {
 			continue
 		}
-		LogWriter.Printf("Removing file : %v, Err : %v", handle.Name(), os.Remove(handle.Name()))
+
+		sum.debug.Printf("Removing file : %v, Err : %v", name, os.Remove(name))
 	}
 
 	for _, temp := range tempFileName {
@@ -209,7 +501,7 @@ func deleteFiles(chunks map[int64]*os.File, tempFileName ...string) error {
 			continue
 		}
 
-		LogWriter.Printf("Removing file : %v, Err : %v", temp, os.Remove(temp))
+		sum.debug.Printf("Removing file : %v, Err : %v", temp, os.Remove(temp))
 
 	}
 
@@ -217,7 +509,7 @@ func deleteFiles(chunks map[int64]*os.File, tempFileName ...string) error {
 }
 
 //createTempOutputFile will create the final output file
-func (sum *summon) createTempOutputFile() error {
+func (sum *Downloader) createTempOutputFile() error {
 
 	//Check if file already exists with same name
 	if fileExists(sum.fileDetails.absolutePath) {
@@ -226,26 +518,58 @@ func (sum *summon) createTempOutputFile() error {
 
 	tempOutFileName := sum.fileDetails.fileDir + sum.separator + "." + sum.fileDetails.fileName
 
-	if isValid, parts := sum.canBeResumed(tempOutFileName); isValid {
-		var shouldResume string
-		fmt.Print("Looks like previous download was incomplete for this file, do you want to resume ? [Y/n] ")
-		_, err := fmt.Scanln(&shouldResume)
-		if err != nil {
+	isValid, parts := sum.canBeResumed(tempOutFileName)
+
+	shouldResume := false
+	if isValid {
+		var err error
+		if shouldResume, err = sum.shouldResume(); err != nil {
 			return err
 		}
+	}
 
-		if shouldResume == "Y" {
-			sum.isResume = true
-			sum.concurrency = int64(len(sum.fileDetails.chunks))
-		} else {
-			//Delete Temp file and chunks both
-			if err := deleteFiles(map[int64]*os.File{}, append(parts, tempOutFileName, sum.getMetaFileName())...); err != nil {
+	if shouldResume {
+		sum.isResume = true
+		sum.direct = sum.metaData.Direct
+		//the remaining ranges are planned again when a different number of connections is asked
+		if sum.defaultConns && sum.metaData.Connections > 0 && sum.metaData.Connections != sum.concurrency {
+			sum.concurrency = sum.metaData.Connections
+			sum.log.Printf("Resuming with the %d connections the download was started with", sum.concurrency)
+			if err := sum.setClient(); err != nil {
 				return err
 			}
 		}
+		if sum.metaData.Connections > 0 && sum.metaData.Connections != sum.concurrency {
+			sum.log.Printf("Download was started with %d connections, resuming with %d", sum.metaData.Connections, sum.concurrency)
+		}
+	} else {
+		//Delete Temp file and chunks both, also when the meta file cannot be resumed so that no part file is left
+		if err := sum.deleteFiles(map[int64]*os.File{}, append(parts, tempOutFileName, sum.getMetaFileName())...); err != nil {
+			return err
+		}
+		sum.fileDetails.chunks = make(map[int64]*os.File)
+		sum.fileDetails.resume = make(map[int64]resume)
 	}
 
-	out, err := os.OpenFile(tempOutFileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	return sum.openTempOutputFile(tempOutFileName)
+}
+
+//openTempOutputFile opens the partial output file, it is truncated unless the download is resumed
+func (sum *Downloader) openTempOutputFile(tempOutFileName string) error {
+
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if sum.direct {
+		//WriteAt is not allowed on files opened in append mode
+		flags = os.O_CREATE | os.O_RDWR
+	}
+
+	//leftover from a download which cannot be resumed. The part files are combined into it only at the end so
+	//what it has is from a combine which did not finish, it would be before the combined bytes
+	if !sum.isResume || !sum.direct {
+		flags |= os.O_TRUNC
+	}
+
+	out, err := os.OpenFile(tempOutFileName, flags, 0644)
 	if err != nil {
 		return fmt.Errorf("error while creating file : %v", err)
 	}
@@ -254,3 +578,21 @@ func (sum *summon) createTempOutputFile() error {
 
 	return nil
 }
+
+//restartWithOneConnection throws away what is downloaded and prepares to download the whole file again over one connection,
+//used when the server ignores the range requests even though it told that it supports them
+func (sum *Downloader) restartWithOneConnection() error {
+
+	sum.deleteFiles(sum.fileDetails.chunks, sum.getMetaFileName())
+
+	sum.fileDetails.chunks = make(map[int64]*os.File)
+	sum.fileDetails.resume = make(map[int64]resume)
+	sum.scheduler.segments = nil
+	sum.err = nil
+	sum.isResume = false
+	sum.isRangeSupported = false
+	sum.concurrency = 1
+	sum.checksum.reset()
+
+	return sum.openTempOutputFile(sum.fileDetails.tempOutFile.Name())
+}
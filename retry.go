@@ -0,0 +1,138 @@
+package summon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_RETRIES         = 5
+	DEFAULT_RETRY_DELAY     = time.Second
+	DEFAULT_RETRY_MAX_DELAY = 30 * time.Second
+)
+
+//statusError is returned when the server responds with an unexpected status code
+type statusError int
+
+func (se statusError) Error() string {
+	return fmt.Sprintf("did not get 20X status code, got : %v %s", int(se), http.StatusText(int(se)))
+}
+
+//errWrite is returned when writing to the disk fails, retrying the request will not help in this case
+type errWrite struct {
+	err error
+}
+
+func (ew errWrite) Error() string {
+	return fmt.Sprintf("error while writing to file : %v", ew.err)
+}
+
+func (ew errWrite) Unwrap() error {
+	return ew.err
+}
+
+//transientErrors are the causes of a failed request which can succeed when it is retried
+var transientErrors = []error{
+	io.ErrUnexpectedEOF,
+	io.EOF,
+	syscall.ECONNRESET,
+	syscall.ECONNREFUSED,
+	syscall.ECONNABORTED,
+	syscall.EPIPE,
+	syscall.ETIMEDOUT,
+	syscall.EHOSTUNREACH,
+	syscall.ENETUNREACH,
+}
+
+//isRetryable tells if the request failed because of a transient error like connection reset, timeout, 5xx or a truncated body
+func isRetryable(err error) bool {
+
+	var se statusError
+	if errors.As(err, &se) {
+		return se >= 500 || se == http.StatusRequestTimeout || se == http.StatusTooManyRequests
+	}
+
+	var ew errWrite
+	if errors.As(err, &ew) {
+		return false
+	}
+
+	if errors.Is(err, errAuthChallenge) {
+		return true
+	}
+
+	for _, te := range transientErrors {
+		if errors.Is(err, te) {
+			return true
+		}
+	}
+
+	var de *net.DNSError
+	if errors.As(err, &de) {
+		return de.IsTemporary || de.IsTimeout
+	}
+
+	//every error of the client is a net.Error, certificate errors and too many redirects are not retried
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+//backoff returns the exponential wait before the next attempt with jitter, attempt starts from 0
+func (sum *Downloader) backoff(attempt int) time.Duration {
+
+	d := sum.retryDelay
+	for i := 0; i < attempt && d < sum.retryMaxDelay; i++ {
+		d *= 2
+	}
+
+	if d > sum.retryMaxDelay {
+		d = sum.retryMaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	//wait anywhere between half and the full delay so that all the connections do not retry at once
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+//sleepContext waits for the duration, it returns early with the context error if the context is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+//restartChunk discards what was written for the segment so that the download can start again, used when the server does not support ranges
+func (sum *Downloader) restartChunk(seg *segment) error {
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if err := seg.w.reset(); err != nil {
+		return fmt.Errorf("error while resetting chunk : %v", err)
+	}
+
+	seg.done = 0
+
+	if seg.start == 0 {
+		sum.checksum.reset()
+	}
+
+	return nil
+}
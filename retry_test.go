@@ -0,0 +1,131 @@
+package summon
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: err}
+	}
+
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"server error":      {err: statusError(http.StatusBadGateway), want: true},
+		"too many requests": {err: statusError(http.StatusTooManyRequests), want: true},
+		"not found":         {err: statusError(http.StatusNotFound)},
+		"write error":       {err: errWrite{err: syscall.ENOSPC}},
+		"truncated body":    {err: fmt.Errorf("error while reading : %w", io.ErrUnexpectedEOF), want: true},
+		"connection reset":  {err: urlErr(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), want: true},
+		"timeout":           {err: urlErr(&net.DNSError{Err: "timeout", IsTimeout: true}), want: true},
+		"dns not found":     {err: urlErr(&net.DNSError{Err: "no such host", IsNotFound: true})},
+		"certificate":       {err: urlErr(x509.UnknownAuthorityError{})},
+		"redirects":         {err: urlErr(errors.New("stopped after 10 redirects"))},
+	}
+
+	for name, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%s : isRetryable(%v) = %v, want %v", name, tt.err, got, tt.want)
+		}
+	}
+}
+
+//cutWriter aborts the response after limit bytes like a proxy which drops the connection
+type cutWriter struct {
+	http.ResponseWriter
+	limit int64
+}
+
+func (cw *cutWriter) Write(b []byte) (int, error) {
+
+	if int64(len(b)) > cw.limit {
+		b = b[:cw.limit]
+	}
+
+	n, _ := cw.ResponseWriter.Write(b)
+	cw.limit -= int64(n)
+
+	if cw.limit == 0 {
+		cw.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	return n, nil
+}
+
+//TestRetryCutConnection checks that a response cut in the middle is downloaded again without leaving wrong bytes in the file
+func TestRetryCutConnection(t *testing.T) {
+
+	size := 3 * MiB
+
+	for _, ranges := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ranges=%v", ranges), func(t *testing.T) {
+
+			var cut int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				//the probe for the first byte is not cut
+				if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" && atomic.CompareAndSwapInt32(&cut, 0, 1) {
+					w = &cutWriter{ResponseWriter: w, limit: 64 * 1024}
+					if !ranges {
+						w.(*cutWriter).limit = size / 2
+					}
+				}
+				if ranges {
+					http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+					return
+				}
+				w.Header().Set("Content-Length", fmt.Sprint(size))
+				if r.Method == http.MethodHead {
+					return
+				}
+				io.Copy(w, &virtualFile{size: size})
+			}))
+			defer srv.Close()
+
+			out := filepath.Join(t.TempDir(), "file.bin")
+
+			sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2), WithRetryBackoff(time.Millisecond, time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sum.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if atomic.LoadInt32(&cut) != 1 {
+				t.Fatal("no response was cut")
+			}
+
+			f, err := os.Open(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			if fi, _ := f.Stat(); fi.Size() != size {
+				t.Fatalf("file size is %d, want %d", fi.Size(), size)
+			}
+
+			checkRange(t, f, 0, size)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package summon
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	SEGMENTS_PER_CONN = 4                 //file is cut into these many segments per connection
+	MIN_SEGMENT_SIZE  = 256 * 1024        //segments are not made smaller than this, an idle connection only splits a segment with at least twice this left
+	UNKNOWN_END       = math.MaxInt64 - 1 //end of the segment when the size of the file is not known, set once the whole file is received
+)
+
+//errSegmentDone is returned by readBody when another connection took over the rest of the segment
+var errSegmentDone = errors.New("segment done")
+
+//segment is a byte range of the file which is downloaded by one connection at a time
+type segment struct {
+	index int64       //index of the segment, segments created by splitting are added at the end
+	start int64       //first byte of the segment
+	end   int64       //last byte of the segment, reduced when an idle connection takes over half of it
+	done  int64       //bytes written till now
+	conn  int64       //connection downloading the segment starting from 1, 0 when none is
+	w     chunkWriter //where the bytes are written
+	sync.Mutex
+}
+
+//remaining returns the bytes which are not written yet, caller should hold the lock
+func (s *segment) remaining() int64 {
+	return s.end - s.start + 1 - s.done
+}
+
+//scheduler hands out the segments to the connections
+type scheduler struct {
+	segments []*segment //ordered by index
+	split    bool       //if the segments can be split, needs range support
+	sync.Mutex
+}
+
+//addSegment adds a new segment to the scheduler
+func (sum *Downloader) addSegment(start, end, done int64, w chunkWriter) *segment {
+
+	sum.scheduler.Lock()
+	defer sum.scheduler.Unlock()
+
+	seg := &segment{index: int64(len(sum.scheduler.segments)), start: start, end: end, done: done, w: w}
+	sum.scheduler.segments = append(sum.scheduler.segments, seg)
+
+	return seg
+}
+
+//nextSegment returns the segment the connection should download next, nil when nothing is left.
+//once every segment is taken the largest remaining segment of a slower connection is split and the connection takes the second half
+func (sum *Downloader) nextSegment(conn int64) (*segment, error) {
+
+	sum.scheduler.Lock()
+	defer sum.scheduler.Unlock()
+
+	var largest *segment
+	var largestRemaining int64
+
+	for _, seg := range sum.scheduler.segments {
+		seg.Lock()
+		remaining, taken := seg.remaining(), seg.conn != 0
+		if !taken && remaining > 0 {
+			seg.conn = conn
+			seg.Unlock()
+			return seg, nil
+		}
+		if taken && remaining > largestRemaining {
+			largest, largestRemaining = seg, remaining
+		}
+		seg.Unlock()
+	}
+
+	if !sum.scheduler.split || largest == nil || largestRemaining < 2*MIN_SEGMENT_SIZE {
+		return nil, nil
+	}
+
+	seg, err := sum.splitSegment(largest, conn)
+	if err != nil || seg == nil {
+		return nil, err
+	}
+
+	sum.scheduler.segments = append(sum.scheduler.segments, seg)
+
+	//the ranges changed so they need to be saved before downloading, otherwise resume would download them twice
+	sum.saveMetaLocked()
+
+	return seg, nil
+}
+
+//splitSegment reduces the segment to half of what is remaining and returns a new segment for the other half, caller should hold the scheduler lock
+func (sum *Downloader) splitSegment(largest *segment, conn int64) (*segment, error) {
+
+	largest.Lock()
+	defer largest.Unlock()
+
+	//bytes might have been written after we checked so take the current values
+	remaining := largest.remaining()
+	if remaining < 2*MIN_SEGMENT_SIZE {
+		return nil, nil
+	}
+
+	start := largest.start + largest.done + remaining - remaining/2
+	end := largest.end
+	index := int64(len(sum.scheduler.segments))
+
+	w, err := sum.createChunkWriter(index, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	sum.debug.Printf("Connection %d took over bytes %d-%d from connection %d", conn, start, end, largest.conn)
+
+	largest.end = start - 1
+
+	return &segment{index: index, start: start, end: end, conn: conn, w: w}, nil
+}
+
+//releaseSegment marks the segment as not being downloaded by any connection
+func (sum *Downloader) releaseSegment(seg *segment) {
+	seg.Lock()
+	seg.conn = 0
+	seg.Unlock()
+}
+
+//startWorkers starts the connections, every connection keeps downloading segments until none are left
+func (sum *Downloader) startWorkers(ctx context.Context, wg *sync.WaitGroup) {
+
+	sum.connLimiters = make(map[int64]*RateLimiter)
+
+	for conn := int64(1); conn <= sum.concurrency; conn++ {
+		if sum.connRate > 0 {
+			sum.connLimiters[conn] = NewRateLimiter(sum.connRate)
+		}
+
+		wg.Add(1)
+		go sum.worker(ctx, wg, conn)
+	}
+}
+
+func (sum *Downloader) worker(ctx context.Context, wg *sync.WaitGroup, conn int64) {
+
+	defer wg.Done()
+
+	for ctx.Err() == nil {
+
+		seg, err := sum.nextSegment(conn)
+		if err != nil {
+			sum.setErr(err)
+			return
+		}
+
+		if seg == nil {
+			return
+		}
+
+		err = sum.downloadFileForRange(ctx, seg)
+		sum.releaseSegment(seg)
+
+		if err != nil {
+			sum.setErr(err)
+			return
+		}
+	}
+
+	sum.setErr(ErrGracefulShutdown)
+}
+
+//received returns the bytes written by all the segments
+func (sum *Downloader) received() int64 {
+
+	sum.scheduler.Lock()
+	defer sum.scheduler.Unlock()
+
+	var n int64
+	for _, seg := range sum.scheduler.segments {
+		seg.Lock()
+		n += seg.done
+		seg.Unlock()
+	}
+
+	return n
+}
+
+//sortedSegments returns the segments ordered by their start
+func (sum *Downloader) sortedSegments() []*segment {
+
+	sum.scheduler.Lock()
+	defer sum.scheduler.Unlock()
+
+	segs := make([]*segment, len(sum.scheduler.segments))
+	copy(segs, sum.scheduler.segments)
+
+	sort.Slice(segs, func(i, j int) bool {
+		return segs[i].start < segs[j].start
+	})
+
+	return segs
+}
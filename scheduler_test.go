@@ -0,0 +1,85 @@
+package summon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//slowWriter flushes every write and waits after it, like a slow link
+type slowWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+}
+
+func (sw slowWriter) Write(b []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(b)
+	sw.ResponseWriter.(http.Flusher).Flush()
+	time.Sleep(sw.delay)
+	return n, err
+}
+
+//TestWorkStealing checks that an idle connection takes over half of the largest remaining segment of a slow connection
+func TestWorkStealing(t *testing.T) {
+
+	size := 8 * MiB
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//only the first segment is slow, the probe for the first byte is not
+		if rg := r.Header.Get("Range"); strings.HasPrefix(rg, "bytes=0-") && rg != "bytes=0-0" {
+			w = slowWriter{ResponseWriter: w, delay: 20 * time.Millisecond}
+		}
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	planned := newRangePlanner(size, 2, true).plan()
+	segs := sum.scheduler.segments
+
+	if len(segs) <= len(planned) {
+		t.Fatalf("got %d segments, want more than the %d planned as the slow one should be split", len(segs), len(planned))
+	}
+
+	//the first split takes the second half of the slow segment, which was the largest one left
+	stolen := segs[len(planned)]
+	if first := segs[0]; first.end >= planned[0].end || stolen.start != first.end+1 || stolen.start <= planned[0].start {
+		t.Fatalf("first segment is %d-%d and the first stolen one is %d-%d, want the slow segment %v split", first.start, first.end, stolen.start, stolen.end, planned[0])
+	}
+
+	ranges := make([]byteRange, len(segs))
+	for i, seg := range segs {
+		ranges[i] = byteRange{start: seg.start, end: seg.end}
+	}
+
+	if err := newRangePlanner(size, 2, true).validate(ranges); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if fi, _ := f.Stat(); fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 0, size)
+}
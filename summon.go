@@ -1,36 +1,77 @@
-package main
+package summon
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
-	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 )
 
-type downloader func(wg *sync.WaitGroup) error
-
-type summon struct {
-	concurrency      int64       //No. of connections
-	uri              string      //URL of the file we want to download
-	isResume         bool        //is this a resume request
-	isRangeSupported bool        //if this request supports range
-	err              error       //used when error occurs inside a goroutine
-	startTime        time.Time   //to track time took
-	fileDetails      fileDetails //will hold the file related details
-	metaData         meta        //Will hold the meta data of the range and file details
-	progressBar      progressBar //index => progress
-	stop             chan error  //to handle stop signals from terminal
-	separator        string      //store the path separator based on the OS
-	*sync.RWMutex                //mutex to lock the maps which accessing it concurrently
+const (
+	MAX_CONN     = 20
+	DEFAULT_CONN = 4
+)
+
+//ErrGracefulShutdown is returned by Run when the download was stopped, the partial download is kept for resuming
+var ErrGracefulShutdown = errors.New("got stop signal")
+
+type downloader func(ctx context.Context, wg *sync.WaitGroup) error
+
+//Downloader downloads a single file using multiple connections, create one using New
+type Downloader struct {
+	concurrency      int64                           //No. of connections
+	uri              string                          //URL of the file we want to download
+	outputPath       string                          //output path passed by the caller, can be empty
+	outputDir        string                          //directory for relative output paths, can be empty
+	isResume         bool                            //is this a resume request
+	isRangeSupported bool                            //if this request supports range
+	err              error                           //used when error occurs inside a goroutine
+	startTime        time.Time                       //to track time took
+	fileDetails      fileDetails                     //will hold the file related details
+	metaData         meta                            //Will hold the meta data of the range and file details
+	scheduler        scheduler                       //segments of the file which are being downloaded
+	progressSink     ProgressSink                    //receives the progress while downloading, can be nil
+	cancel           context.CancelFunc              //stops all the connections when one of them fails
+	separator        string                          //store the path separator based on the OS
+	log              Logger                          //logs which should be shown to the user
+	debug            Logger                          //verbose logs
+	confirmResume    func(path string) (bool, error) //asked before resuming an incomplete download
+	resumePolicy     ResumePolicy                    //what is done with an incomplete download
+	force            bool                            //incomplete download is always deleted
+	defaultConns     bool                            //number of connections was not passed, a resumed download uses the connections it was started with
+	lock             *os.File                        //lock file of the output, held while downloading
+	lockMode         LockMode                        //what is done when another process is downloading the same file
+	retries          int                             //how many times a failed connection is retried
+	retryDelay       time.Duration                   //wait before the first retry, doubled for every retry
+	retryMaxDelay    time.Duration                   //maximum wait between the retries
+	direct           bool                            //write the chunks directly into the output file instead of part files
+	checksum         *checksum                       //expected checksum of the file, can be nil
+	keepCorrupt      bool                            //keep the downloaded file when the checksum does not match
+	serverChecksum   bool                            //verify the file against the checksum sent by the server in the headers
+	limiter          *RateLimiter                    //limits the bytes read per second by all the connections, can be shared with other downloads
+	connRate         int64                           //bytes per second allowed for each connection, 0 means unlimited
+	connLimiters     map[int64]*RateLimiter          //key is the connection & value is its limiter
+	method           string                          //method used for downloading, probing is always done with HEAD or GET
+	headers          http.Header                     //added to every request
+	cookieJar        http.CookieJar                  //cookies sent with every request, can be nil
+	auth             credentials                     //used for authenticating with the server
+	proxy            *url.URL                        //proxy passed by the user, nil means the proxy environment variables are used
+	noProxy          string                          //hosts which are not proxied when the proxy is passed by the user
+	transport        *http.Transport                 //transport of the client, kept to close the idle connections
+	client           *http.Client                    //used by all the requests of the download
+	http2            bool                            //use HTTP/2 when the server supports it, the ranges are then sent over one connection
+	protocol         string                          //protocol used by the server, for example HTTP/1.1
+	caps             *capabilities                   //capabilities of the server for the file, set by the probe
+	connectTimeout   time.Duration                   //timeout of the dial and the TLS handshake
+	responseTimeout  time.Duration                   //timeout for the headers of the response
+	tls              tlsOptions                      //used for the tls config of the transport
+	*sync.RWMutex                                    //mutex to lock the maps which accessing it concurrently
 }
 
 type fileDetails struct {
@@ -43,57 +84,76 @@ type fileDetails struct {
 	contentLength int64
 }
 
-func NewSummon() (*summon, error) {
-
-	args := arguments{}
-
-	parseFlags(&args)
-
-	if args.help {
-		flag.PrintDefaults()
-		fmt.Println("\nExample Usage - $GOBIN/summon -c 5 http://www.africau.edu/images/default/sample.pdf")
-		os.Exit(0)
-	}
-
-	//Set logger
-	setLogWriter(args.verbose)
-
-	sum := new(summon)
+//New returns a Downloader for the passed url configured with the passed options
+func New(fileURL string, opts ...Option) (*Downloader, error) {
 
-	fileURL, err := validate()
+	uri, err := validate(fileURL)
 	if err != nil {
-		return sum, err
+		return nil, err
 	}
 
-	sum.uri = fileURL
+	sum := new(Downloader)
+
+	sum.uri = uri
 	sum.fileDetails.chunks = make(map[int64]*os.File)
-	sum.startTime = time.Now()
-	sum.fileDetails.fileName = filepath.Base(sum.uri)
+	sum.fileDetails.fileName = fileNameFromURL(sum.uri)
 	sum.RWMutex = &sync.RWMutex{}
-	sum.progressBar.RWMutex = &sync.RWMutex{}
-	sum.progressBar.p = make(map[int64]*progress)
-	sum.stop = make(chan error)
 	sum.separator = string(os.PathSeparator)
 	sum.fileDetails.resume = make(map[int64]resume)
+	sum.log = ProdLogger{}
+	sum.debug = ProdLogger{}
+	sum.retries = DEFAULT_RETRIES
+	sum.retryDelay = DEFAULT_RETRY_DELAY
+	sum.retryMaxDelay = DEFAULT_RETRY_MAX_DELAY
+	sum.serverChecksum = true
+	sum.method = http.MethodGet
+	sum.headers = make(http.Header)
+	sum.noProxy = defaultNoProxy()
+	sum.connectTimeout = DEFAULT_CONNECT_TIMEOUT
+	sum.responseTimeout = DEFAULT_RESPONSE_TIMEOUT
+	sum.lockMode = LOCK_FAIL
+
+	for _, opt := range opts {
+		if err := opt(sum); err != nil {
+			return nil, err
+		}
+	}
 
-	sum.setConcurrency(args.connections)
-	sum.setAbsolutePath(args.outputFile)
-	sum.setFileDir()
+	//the confirm function was the only way to choose before the policies
+	if sum.resumePolicy == 0 {
+		sum.resumePolicy = RESUME_AUTO
+		if sum.confirmResume != nil {
+			sum.resumePolicy = RESUME_ASK
+		}
+	}
 
-	if err := sum.createTempOutputFile(); err != nil {
+	if sum.force {
+		sum.resumePolicy = RESUME_NEVER
+	}
+
+	if err := sum.setCredentials(); err != nil {
+		return nil, err
+	}
+
+	sum.setConcurrency(sum.concurrency)
+
+	if err := sum.setClient(); err != nil {
 		return nil, err
 	}
 
+	if sum.tls.insecure {
+		sum.log.Printf("WARNING : certificate of the server is not verified, the download can be read and changed by anyone in between")
+	}
+
 	return sum, nil
 
 }
 
-func validate() (string, error) {
-	if len(flag.Args()) <= 0 {
+func validate(u string) (string, error) {
+	if u == "" {
 		return "", fmt.Errorf("please pass file url")
 	}
 
-	u := flag.Args()[0]
 	uri, err := url.ParseRequestURI(u)
 	if err != nil {
 		return "", fmt.Errorf("passed URL is invalid")
@@ -102,15 +162,134 @@ func validate() (string, error) {
 	return uri.String(), nil
 }
 
-//process is the manager method
-func (sum *summon) process() error {
+//Run is basically the start method, it downloads the file and returns once the download has finished.
+//Cancelling the context stops all the connections, Run then returns ErrGracefulShutdown and the partial download is kept for resuming.
+func (sum *Downloader) Run(ctx context.Context) error {
+
+	sum.startTime = time.Now()
+
+	//connections are not shared between downloads, the idle ones would otherwise stay open until IDLE_CONN_TIMEOUT
+	defer sum.closeIdleConnections()
+
+	caps, err := sum.probe(ctx)
+	if err != nil {
+		return fmt.Errorf("error calling url : %v", err)
+	}
+
+	sum.caps = caps
+
+	if caps.finalURL != sum.uri {
+		sum.debug.Printf("Redirected to : %v", redactURL(caps.finalURL))
+	}
+
+	if err := sum.setAbsolutePath(sum.outputPath); err != nil {
+		return err
+	}
+
+	sum.setFileDir()
+
+	if err := os.MkdirAll(sum.fileDetails.fileDir, 0755); err != nil {
+		return fmt.Errorf("error while creating output directory : %v", err)
+	}
+
+	//taken before looking at the partial files as another process could be writing them
+	done, err := sum.lockOutput(ctx)
+	if err != nil {
+		return err
+	}
+	defer sum.unlockOutput()
 
-	progressSize = getProgressSize()
+	if done {
+		return nil
+	}
+
+	if err := sum.createTempOutputFile(); err != nil {
+		return err
+	}
+
+	isSupported, contentLength := caps.ranges, caps.size
+
+	sum.setServerChecksum(caps.headers)
+
+	sum.fileDetails.contentLength = contentLength
+	sum.isRangeSupported = isSupported
+
+	if !isSupported && !sum.isResume {
+		sum.concurrency = 1
+	}
+
+	//the file cannot be cut into segments without knowing the size so it is streamed over one connection
+	if contentLength < 0 {
+		sum.concurrency = 1
+	}
+
+	sum.log.Printf("Multiple Connections Supported : %v", isSupported)
+	if contentLength < 0 {
+		sum.log.Printf("Content Length is not known, the size will be known once downloaded")
+	} else {
+		sum.log.Printf("Got Content Length : %v", HumanSizeFromBytes(contentLength))
+	}
+	sum.log.Printf("Using %v connections", sum.concurrency)
+	sum.log.Printf("Protocol : %v", sum.protocol)
+
+	err = sum.process(ctx)
+
+	//the parts downloaded till now cannot be trusted, start again without ranges
+	if errors.Is(err, errRangeIgnored) {
+		sum.log.Printf("Server ignored the range request and sent the whole file, downloading again using 1 connection")
+		if err = sum.restartWithOneConnection(); err == nil {
+			err = sum.process(ctx)
+		}
+	}
+
+	if err == nil {
+		sum.debug.Printf("Success, Now Cleaning Up")
+		return sum.deleteFiles(sum.fileDetails.chunks, sum.getMetaFileName())
+	}
+
+	//keep the downloaded file for inspection, only the chunks are removed
+	if errors.Is(err, ErrChecksumMismatch) && sum.keepCorrupt {
+		sum.log.Printf("Keeping the downloaded file at : %v", sum.fileDetails.tempOutFile.Name())
+		sum.deleteFiles(sum.fileDetails.chunks, sum.getMetaFileName())
+		return err
+	}
+
+	//if there was some error we will delete the files except unless its gracefully stopped
+	if err != ErrGracefulShutdown {
+		sum.debug.Printf("Some error occured Cleaning Up, Error : %v", err)
+		sum.deleteFiles(sum.fileDetails.chunks, sum.fileDetails.tempOutFile.Name(), sum.getMetaFileName())
+	}
+
+	return err
+
+}
+
+//OutputPath returns the absolute path of the downloaded file, it is set once Run has started
+func (sum *Downloader) OutputPath() string {
+	return sum.fileDetails.absolutePath
+}
+
+//Protocol returns the protocol used by the server, for example HTTP/1.1 or HTTP/2.0. It is set once Run has started
+func (sum *Downloader) Protocol() string {
+	return sum.protocol
+}
+
+//Size returns the size of the file in bytes, it is set once Run has checked the url.
+//It is -1 while downloading a file whose size is not sent by the server
+func (sum *Downloader) Size() int64 {
+	return sum.fileDetails.contentLength
+}
+
+//process is the manager method
+func (sum *Downloader) process(ctx context.Context) error {
 
 	//pwg is the progressbar waitgroup
 	wg, pWg := &sync.WaitGroup{}, &sync.WaitGroup{}
 
-	if err := sum.getDownloader()(wg); err != nil {
+	ctx, sum.cancel = context.WithCancel(ctx)
+	defer sum.cancel()
+
+	if err := sum.getDownloader()(ctx, wg); err != nil {
 		return err
 	}
 
@@ -132,14 +311,22 @@ func (sum *summon) process() error {
 	//Wait for progressbar function to stop
 	pWg.Wait()
 
+	//keep the final progress so that the download can be resumed
+	sum.saveMeta()
+
 	if sum.err != nil {
 		return sum.err
 	}
 
+	//parent context was cancelled, none of the connections failed
+	if ctx.Err() != nil {
+		return ErrGracefulShutdown
+	}
+
 	return sum.combineChunks()
 }
 
-func (sum *summon) getDownloader() downloader {
+func (sum *Downloader) getDownloader() downloader {
 
 	if sum.isResume {
 		return sum.resumeDownload
@@ -148,19 +335,20 @@ func (sum *summon) getDownloader() downloader {
 	return sum.download
 }
 
-func (sum summon) getTempFileName(index, start, end int64) (string, error) {
+func (sum *Downloader) getTempFileName(index, start, end int64) (string, error) {
 
 	return fmt.Sprintf("%s%s.%s.sump%d", sum.fileDetails.fileDir, sum.separator, sum.fileDetails.fileName, index), nil
 
 }
 
 //setConcurrency set the concurrency as per min and max
-func (sum *summon) setConcurrency(c int64) {
+func (sum *Downloader) setConcurrency(c int64) {
 
 	//We use default connections in case no concurrency is passed
 	if c <= 0 {
-		log.Println("Using default number of connections", DEFAULT_CONN)
+		sum.log.Println("Using default number of connections", DEFAULT_CONN)
 		sum.concurrency = DEFAULT_CONN
+		sum.defaultConns = true
 		return
 	}
 
@@ -172,77 +360,113 @@ func (sum *summon) setConcurrency(c int64) {
 	sum.concurrency = c
 }
 
-func (sum *summon) setAbsolutePath(opath string) error {
+func (sum *Downloader) setAbsolutePath(opath string) error {
 
 	if opath == "" {
 
-		filename, err := getFileNameFromHeaders(sum.uri)
-		if err != nil {
-			return err
-		}
-
-		if filename == "" {
+		if sum.caps.filename == "" {
 			//Get the filename from the url
-			opath = filepath.Base(sum.uri)
+			opath = fileNameFromURL(sum.uri)
 		} else {
-			LogWriter.Printf("Got Filename from headers : %v", filename)
-			sum.fileDetails.fileName = filename
-			opath = filename
+			sum.debug.Printf("Got Filename from headers : %v", sum.caps.filename)
+			opath = sum.caps.filename
 		}
 
 	}
 
+	//relative paths are inside the output directory if one is set
+	if !filepath.IsAbs(opath) && sum.outputDir != "" {
+		opath = filepath.Join(sum.outputDir, opath)
+	}
+
 	if filepath.IsAbs(opath) {
-		LogWriter.Printf("path passed is an absolute path")
+		sum.debug.Printf("path passed is an absolute path")
 		sum.fileDetails.absolutePath = opath
+		sum.fileDetails.fileName = filepath.Base(opath)
 		return nil
 	}
 
 	absPath, err := filepath.Abs(opath)
 	if err != nil {
-		LogWriter.Printf("error while getting absolute path : %v", err)
+		sum.debug.Printf("error while getting absolute path : %v", err)
 		return err
 	}
-	LogWriter.Printf("Final absolute path is : %v", absPath)
+	sum.debug.Printf("Final absolute path is : %v", absPath)
 
 	sum.fileDetails.absolutePath = absPath
+	sum.fileDetails.fileName = filepath.Base(absPath)
 
 	return nil
 }
 
-func (sum *summon) setFileDir() {
+func (sum *Downloader) setFileDir() {
 	sum.fileDetails.fileDir = filepath.Dir(sum.fileDetails.absolutePath)
 }
 
-//combineChunks will combine the chunks in ordered fashion starting from 1
-func (sum *summon) combineChunks() error {
-
-	LogWriter.Printf("Combining the files...")
+//combineChunks will combine the chunks in the order of their range
+func (sum *Downloader) combineChunks() error {
 
 	var w int64
-	//maps are not ordered hence using for loop
-	for i := int64(0); i < int64(len(sum.fileDetails.chunks)); i++ {
-		handle := sum.fileDetails.chunks[i]
+
+	//size was not known so it is what we have received
+	if sum.fileDetails.contentLength < 0 {
+		sum.fileDetails.contentLength = sum.received()
+	}
+
+	if sum.direct {
+		//connections already wrote at their offsets so there is nothing to combine
+		w = sum.fileDetails.contentLength
+	} else {
+		sum.debug.Printf("Combining the files...")
+	}
+
+	for _, seg := range sum.sortedSegments() {
+		handle := sum.fileDetails.chunks[seg.index]
 
 		if handle == nil {
-			return fmt.Errorf("got chunk handle nil")
+			continue
 		}
 
 		handle.Seek(0, 0) //We need to seek because read and write cursor are same and the cursor would be at the end.
-		written, err := io.Copy(sum.fileDetails.tempOutFile, handle)
+
+		//hash while copying so that the file is not read again
+		out := io.Writer(sum.fileDetails.tempOutFile)
+		if sum.checksum != nil {
+			out = io.MultiWriter(out, &offsetHasher{c: sum.checksum, off: seg.start})
+		}
+
+		written, err := io.Copy(out, handle)
 		if err != nil {
 			return fmt.Errorf("error occured while copying to temp file : %v", err)
 		}
 		w += written
 	}
 
+	if w != sum.fileDetails.contentLength {
+		return fmt.Errorf("wrote %d bytes but content length is %d", w, sum.fileDetails.contentLength)
+	}
+
+	//the file can have more than what was written if it was not empty
+	info, err := sum.fileDetails.tempOutFile.Stat()
+	if err != nil {
+		return fmt.Errorf("error while checking temp file : %v", err)
+	}
+
+	if info.Size() != sum.fileDetails.contentLength {
+		return fmt.Errorf("temp file has %d bytes but content length is %d", info.Size(), sum.fileDetails.contentLength)
+	}
+
+	if err := sum.verifyChecksum(sum.fileDetails.tempOutFile, w); err != nil {
+		return err
+	}
+
 	tempFileName := sum.fileDetails.tempOutFile.Name()
 
 	finalFileName := sum.fileDetails.fileDir + sum.separator + sum.fileDetails.fileName
 
-	log.Printf("Wrote to File : %v, Written : %v", finalFileName, humanSizeFromBytes(w))
+	sum.log.Printf("Wrote to File : %v, Written : %v", finalFileName, HumanSizeFromBytes(w))
 
-	LogWriter.Printf("Renaming File from : %v to %v", tempFileName, finalFileName)
+	sum.debug.Printf("Renaming File from : %v to %v", tempFileName, finalFileName)
 
 	if err := os.Rename(tempFileName, finalFileName); err != nil {
 		return fmt.Errorf("error occured while renaming file : %v", err)
@@ -251,180 +475,229 @@ func (sum *summon) combineChunks() error {
 	return nil
 }
 
-//downloadFileForRange will download the file for the provided range and set the bytes to the chunk map, will set summor.error field if error occurs
-func (sum *summon) downloadFileForRange(wg *sync.WaitGroup, r string, index int64, handle io.Writer) {
+//downloadFileForRange will download the range of the segment and write it to its chunk writer, failed requests are retried from the last written byte.
+//it returns an error once the retries are exhausted
+func (sum *Downloader) downloadFileForRange(ctx context.Context, seg *segment) error {
 
-	LogWriter.Printf("Downloading for range : %s , for index : %d", r, index)
-	defer wg.Done()
+	for attempt := 0; ; attempt++ {
 
-	request, err := http.NewRequest("GET", sum.uri, strings.NewReader(""))
-	if err != nil {
-		sum.Lock()
-		sum.err = err
-		sum.Unlock()
-		return
-	}
+		seg.Lock()
+		start, end := seg.start+seg.done, seg.end
+		seg.Unlock()
 
-	request.Header.Add("Range", "bytes="+r)
+		//segment is already completed
+		if start > end {
+			return nil
+		}
 
-	client := http.Client{Timeout: 0}
+		//without range support the segment can only be downloaded from its start
+		if !sum.isRangeSupported && start > seg.start {
+			if err := sum.restartChunk(seg); err != nil {
+				return err
+			}
+			start = seg.start
+		}
 
-	response, err := client.Do(request)
-	if err != nil {
-		sum.Lock()
-		sum.err = err
-		sum.Unlock()
-		return
-	}
+		err := sum.fetchRange(ctx, seg, start, end)
+		if err == nil {
+			return nil
+		}
 
-	//206 = Partial Content
-	if response.StatusCode != 200 && response.StatusCode != 206 {
-		sum.Lock()
-		sum.err = fmt.Errorf("did not get 20X status code, got : %v", response.StatusCode)
-		sum.Unlock()
-		log.Println(sum.err)
-		return
-	}
+		if ctx.Err() != nil {
+			return ErrGracefulShutdown
+		}
 
-	if err := sum.getDataAndWriteToFile(response.Body, handle, index); err != nil {
-		sum.Lock()
-		sum.err = err
-		sum.Unlock()
-		return
+		//the download is started again over one connection
+		if errors.Is(err, errRangeIgnored) {
+			return err
+		}
+
+		if !isRetryable(err) || attempt >= sum.retries {
+			if attempt > 0 {
+				err = fmt.Errorf("giving up on segment %d after %d retries : %v", seg.index+1, attempt, err)
+			}
+			sum.log.Println(err)
+			return err
+		}
+
+		wait := sum.backoff(attempt)
+		sum.debug.Printf("Segment %d failed : %v, retrying in %v", seg.index+1, err, wait)
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return ErrGracefulShutdown
+		}
 	}
 
 }
 
-//getRangeDetails returns ifRangeIsSupported,statuscode,error
-func getRangeDetails(u string) (bool, int64, error) {
+//fetchRange does a single request for the range and writes the body to the segment
+func (sum *Downloader) fetchRange(ctx context.Context, seg *segment, start, end int64) error {
 
-	request, err := http.NewRequest("HEAD", u, strings.NewReader(""))
-	if err != nil {
-		return false, 0, fmt.Errorf("error while creating request : %v", err)
+	r := fmt.Sprintf("%d-%d", start, end)
+	if end == UNKNOWN_END {
+		r = fmt.Sprintf("%d-", start)
 	}
 
-	sc, headers, _, err := doAPICall(request)
+	sum.debug.Printf("Downloading for range : %s , for segment : %d", r, seg.index)
+
+	request, err := sum.newRequest(ctx, sum.method, sum.uri)
 	if err != nil {
-		return false, 0, fmt.Errorf("error calling url : %v", err)
+		return err
 	}
 
-	if sc != 200 && sc != 206 {
-		return false, 0, fmt.Errorf("did not get 200 or 206 response")
+	//whole file is asked without a range when the size is not known, as some servers do not support ranges for generated files
+	if end != UNKNOWN_END || start > 0 {
+		request.Header.Add("Range", "bytes="+r)
+		if v := sum.caps.ifRange(); v != "" {
+			request.Header.Set("If-Range", v)
+		}
 	}
 
-	conLen := headers.Get("Content-Length")
-
-	cl, err := parseint64(conLen)
+	response, err := sum.client.Do(request)
 	if err != nil {
-		return false, 0, fmt.Errorf("error Parsing content length : %v", err)
+		return sum.proxyError(request, err)
 	}
 
-	//Accept-Ranges: bytes
-	if headers.Get("Accept-Ranges") == "bytes" {
-		return true, cl[0], nil
+	if err := sum.proxyAuthError(request, response); err != nil {
+		response.Body.Close()
+		return err
 	}
 
-	return false, cl[0], nil
-
-}
-
-//doAPICall will do the api call and return statuscode,headers,data,error respectively
-func doAPICall(request *http.Request) (int, http.Header, []byte, error) {
+	if sum.handleChallenge(response) {
+		response.Body.Close()
+		return errAuthChallenge
+	}
 
-	client := http.Client{
-		Timeout: 5 * time.Second,
+	if err := sum.checkRangeResponse(response, start, end, request.Header.Get("Range") != ""); err != nil {
+		response.Body.Close()
+		return err
 	}
 
-	response, err := client.Do(request)
+	n, err := sum.getDataAndWriteToFile(ctx, response.Body, seg)
 	if err != nil {
-		return 0, http.Header{}, []byte{}, fmt.Errorf("error while doing request : %v", err)
+		return err
 	}
-	defer response.Body.Close()
 
-	data, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return 0, http.Header{}, []byte{}, fmt.Errorf("error while reading response body : %v", err)
+	//the server closed the connection before sending the whole range
+	seg.Lock()
+	//the whole file is received when the size is not known
+	if seg.end == UNKNOWN_END {
+		seg.end = seg.start + seg.done - 1
 	}
+	remaining := seg.remaining()
+	seg.Unlock()
 
-	return response.StatusCode, response.Header, data, nil
+	if remaining > 0 {
+		return fmt.Errorf("got %d bytes, %d bytes are missing : %w", n, remaining, io.ErrUnexpectedEOF)
+	}
 
+	return nil
 }
 
-func getFileNameFromHeaders(u string) (string, error) {
+//setErr keeps the first error which occured inside a goroutine and stops the other connections
+func (sum *Downloader) setErr(err error) {
 
-	request, err := http.NewRequest("HEAD", u, strings.NewReader(""))
-	if err != nil {
-		return "", err
+	sum.Lock()
+	defer sum.Unlock()
+
+	if sum.err == nil {
+		sum.err = err
 	}
 
-	sc, headers, _, err := doAPICall(request)
+	sum.cancel()
+}
+
+//doAPICall does the request answering the authentication challenge of the server, the caller should close the body of the response
+func (sum *Downloader) doAPICall(request *http.Request) (*http.Response, error) {
+
+	response, err := sum.client.Do(request)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("error while doing request : %v", sum.proxyError(request, err))
 	}
 
-	if sc != 200 {
-		return "", fmt.Errorf("did not get 200 response in getFileNameFromHeaders : %v", sc)
+	if err := sum.proxyAuthError(request, response); err != nil {
+		response.Body.Close()
+		return nil, err
 	}
 
-	cd := headers.Get("Content-Disposition")
+	//the server wants authentication, try again with the credentials
+	if sum.handleChallenge(response) {
+		response.Body.Close()
 
-	//Content-Disposition is not present so filename is not there
-	if cd == "" {
-		LogWriter.Printf("getFileNameFromHeaders got content disposation empty")
-		return "", nil
-	}
+		retry := request.Clone(request.Context())
+		if sum.headers.Get("Authorization") == "" {
+			retry.Header.Del("Authorization")
+		}
+		if err := sum.setAuthorization(retry); err != nil {
+			return nil, err
+		}
 
-	_, params, err := mime.ParseMediaType(cd)
-	LogWriter.Printf("params : %v", params)
-	if err != nil {
-		return "", err
+		if response, err = sum.client.Do(retry); err != nil {
+			return nil, fmt.Errorf("error while doing request : %v", sum.proxyError(retry, err))
+		}
 	}
 
-	return params["filename"], nil
+	return response, nil
 }
 
-//getDataAndWriteToFile will get the response and write to file
-func (sum *summon) getDataAndWriteToFile(body io.ReadCloser, f io.Writer, index int64) error {
+//getDataAndWriteToFile will get the response and write to file, the body is bound to the request context so a blocked read returns once it is cancelled.
+//it returns the bytes written to the file
+func (sum *Downloader) getDataAndWriteToFile(ctx context.Context, body io.ReadCloser, seg *segment) (int64, error) {
 
 	defer body.Close()
 
 	//we make buffer of 500 bytes and try to read 500 bytes every iteration.
 	var buf = make([]byte, 500)
 
-	defer startTimer("Time took for chunk : %v is", index)()
+	defer sum.startTimer("Time took for segment : %v is", seg.index)()
 
+	var written int64
 	for {
-		select {
-		case <-sum.stop:
-			return ErrGracefulShutdown
-		default:
-			err := sum.readBody(body, f, buf, index)
-			if err == io.EOF {
-				return nil
-			}
+		r, err := sum.readBody(body, seg, buf)
+		written += int64(r)
+		if err == io.EOF || err == errSegmentDone {
+			return written, nil
+		}
 
-			if err != nil {
-				return err
-			}
+		if err == nil {
+			err = sum.throttle(ctx, seg, r)
+		}
+
+		if ctx.Err() != nil {
+			return written, ErrGracefulShutdown
+		}
+
+		if err != nil {
+			return written, err
 		}
 	}
 }
 
-func (sum *summon) readBody(body io.Reader, f io.Writer, buf []byte, index int64) error {
+//readBody reads from the body and writes to the segment, it never writes past the end of the segment as it can be reduced by another connection
+func (sum *Downloader) readBody(body io.Reader, seg *segment, buf []byte) (int, error) {
 
 	r, err := body.Read(buf)
 
 	if r > 0 {
-		f.Write(buf[:r])
+		seg.Lock()
+		defer seg.Unlock()
 
-		sum.progressBar.Lock()
-		sum.progressBar.p[index].curr += int64(r)
-		sum.progressBar.Unlock()
+		if remaining := seg.remaining(); int64(r) >= remaining {
+			r, err = int(remaining), errSegmentDone
+		}
+
+		if _, err := seg.w.Write(buf[:r]); err != nil {
+			return 0, errWrite{err}
+		}
+
+		//bytes are hashed while streaming when they are written in order, like with a single connection
+		sum.checksum.writeAt(buf[:r], seg.start+seg.done)
+		seg.done += int64(r)
 	}
 
 	if err != nil {
-		return err
+		return r, err
 	}
 
-	return nil
+	return r, nil
 }
@@ -0,0 +1,954 @@
+package summon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	GiB = int64(1) << 30
+	MiB = int64(1) << 20
+)
+
+//virtualFile is a file of any size which is generated from the offset, nothing is stored
+type virtualFile struct {
+	size int64
+	off  int64
+}
+
+//byteAt returns the content at the offset, it uses the high bits so that an offset truncated to 32 bits gives different content
+func byteAt(off int64) byte {
+	return byte(off*131 + off>>8 + off>>16*7 + off>>24*13 + off>>32*29)
+}
+
+func (vf *virtualFile) Read(p []byte) (int, error) {
+
+	if vf.off >= vf.size {
+		return 0, io.EOF
+	}
+
+	if rem := vf.size - vf.off; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+
+	for i := range p {
+		p[i] = byteAt(vf.off + int64(i))
+	}
+
+	vf.off += int64(len(p))
+
+	return len(p), nil
+}
+
+func (vf *virtualFile) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += vf.off
+	case io.SeekEnd:
+		offset += vf.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	vf.off = offset
+
+	return offset, nil
+}
+
+//newRangeServer serves a virtual file of the size with range support, head tells if HEAD requests are allowed
+func newRangeServer(t *testing.T, size int64, head bool) *httptest.Server {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !head && r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+//checkRange compares the bytes of the file at the offset with the virtual file
+func checkRange(t *testing.T, f *os.File, off, n int64) {
+
+	t.Helper()
+
+	got := make([]byte, n)
+	if _, err := f.ReadAt(got, off); err != nil {
+		t.Fatalf("error while reading %d bytes at %d : %v", n, off, err)
+	}
+
+	want := make([]byte, n)
+	(&virtualFile{size: off + n, off: off}).Read(want)
+
+	if !bytes.Equal(got, want) {
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("byte at %d is %d, want %d", off+int64(i), got[i], want[i])
+			}
+		}
+	}
+}
+
+//saveMeta writes the meta file of a partial download
+func saveMeta(t *testing.T, path string, m meta) {
+
+	t.Helper()
+
+	m.Version = META_VERSION
+	if err := writeMeta(path, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseInt64(t *testing.T) {
+
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "4294967295", want: 4294967295},
+		{in: "4294967296", want: 4294967296},
+		{in: "5368709120", want: 5 * GiB},
+		{in: "9223372036854775807", want: 9223372036854775807},
+		{in: "-1", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseint64(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseint64(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got[0] != tt.want {
+			t.Errorf("parseint64(%q) = %d, want %d", tt.in, got[0], tt.want)
+		}
+	}
+}
+
+func TestParseContentRangeSize(t *testing.T) {
+
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "bytes 0-0/1234", want: 1234},
+		{in: "bytes 0-0/6442450944", want: 6 * GiB},
+		{in: "bytes */0", want: 0},
+		{in: "bytes 0-0/*", want: -1},
+		{in: "0-0/1234", wantErr: true},
+		{in: "bytes 0-0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseContentRangeSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseContentRangeSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseContentRangeSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+
+	tests := []struct {
+		in                 string
+		first, last, total int64
+		wantErr            bool
+	}{
+		{in: "bytes 0-1023/1234", first: 0, last: 1023, total: 1234},
+		{in: "bytes 4294967296-6442450943/6442450944", first: 4 * GiB, last: 6*GiB - 1, total: 6 * GiB},
+		{in: "bytes 100-199/*", first: 100, last: 199, total: -1},
+		{in: "bytes */1234", first: -1, last: -1, total: 1234},
+		{in: "bytes 100/1234", wantErr: true},
+		{in: "bytes a-b/1234", wantErr: true},
+		{in: "bytes -1-5/1234", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		first, last, total, err := parseContentRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseContentRange(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (first != tt.first || last != tt.last || total != tt.total) {
+			t.Errorf("parseContentRange(%q) = %d %d %d, want %d %d %d", tt.in, first, last, total, tt.first, tt.last, tt.total)
+		}
+	}
+}
+
+func TestPlanLarge(t *testing.T) {
+
+	for _, size := range []int64{4*GiB - 1, 4 * GiB, 4*GiB + 1, 5*GiB + 12345, 1 << 40} {
+		for _, c := range []int64{1, 3, 8, MAX_CONN} {
+
+			p := newRangePlanner(size, c, true)
+			if err := p.validate(p.plan()); err != nil {
+				t.Fatalf("size %d, connections %d : %v", size, c, err)
+			}
+		}
+	}
+}
+
+func TestProbeLargeFile(t *testing.T) {
+
+	size := 6*GiB + 7
+
+	for _, head := range []bool{true, false} {
+
+		srv := newRangeServer(t, size, head)
+
+		sum, err := New(srv.URL + "/large.img")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		caps, err := sum.probe(context.Background())
+		if err != nil {
+			t.Fatalf("head %v : %v", head, err)
+		}
+
+		if caps.size != size || !caps.ranges {
+			t.Fatalf("head %v : got size %d ranges %v, want size %d with ranges", head, caps.size, caps.ranges, size)
+		}
+	}
+}
+
+//TestDownloadExact downloads a small file with odd size using many segments and checks every byte
+func TestDownloadExact(t *testing.T) {
+
+	size := 3*MiB + 12345
+
+	for _, direct := range []bool{false, true} {
+
+		srv := newRangeServer(t, size, true)
+		out := filepath.Join(t.TempDir(), "file.bin")
+
+		sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(5), WithDirectWrite(direct))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sum.Run(context.Background()); err != nil {
+			t.Fatalf("direct %v : %v", direct, err)
+		}
+
+		f, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fi, _ := f.Stat()
+		if fi.Size() != size {
+			t.Fatalf("direct %v : file size is %d, want %d", direct, fi.Size(), size)
+		}
+
+		checkRange(t, f, 0, size)
+		f.Close()
+	}
+}
+
+//TestResumeAcross4GiB resumes a direct download of a sparse file larger than 4 GiB where only the segments around 4 GiB
+//and at the end are left, so that the offsets above 32 bits are downloaded without writing gigabytes
+func TestResumeAcross4GiB(t *testing.T) {
+
+	size := 4*GiB + 3*MiB + 17
+	dir := t.TempDir()
+	out := filepath.Join(dir, "large.img")
+
+	//sparse partial output, nothing is written to the disk
+	temp, err := os.Create(filepath.Join(dir, ".large.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := temp.Truncate(size); err != nil {
+		t.Skipf("sparse files are not supported : %v", err)
+	}
+	temp.Close()
+
+	saveMeta(t, filepath.Join(dir, ".large.img.summon.meta"), meta{
+		Size:        size,
+		Direct:      true,
+		Connections: 2,
+		Chunks: []metaChunk{
+			{Start: 0, End: 4*GiB - MiB - 1, Done: 4*GiB - MiB},
+			{Start: 4*GiB - MiB, End: 4*GiB + MiB - 1, Done: 0},
+			{Start: 4*GiB + MiB, End: size - 1, Done: MiB},
+		},
+	})
+
+	srv := newRangeServer(t, size, true)
+
+	sum, err := New(srv.URL+"/large.img", WithOutput(out), WithConnections(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if sum.Size() != size {
+		t.Fatalf("Size() is %d, want %d", sum.Size(), size)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, _ := f.Stat()
+	if fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 4*GiB-MiB, 2*MiB)
+	checkRange(t, f, 4*GiB+2*MiB, size-4*GiB-2*MiB)
+
+	if fileExists(filepath.Join(dir, ".large.img.summon.meta")) {
+		t.Fatal("meta file is not removed")
+	}
+}
+
+//TestDownloadHuge downloads a multi GB file completely, it needs the disk space so it only runs with SUMMON_TEST_HUGE=1
+func TestDownloadHuge(t *testing.T) {
+
+	if os.Getenv("SUMMON_TEST_HUGE") != "1" {
+		t.Skip("set SUMMON_TEST_HUGE=1 to download a 5 GiB file")
+	}
+
+	size := 5*GiB + 3
+	srv := newRangeServer(t, size, true)
+	out := filepath.Join(t.TempDir(), "huge.img")
+
+	sum, err := New(srv.URL+"/huge.img", WithOutput(out), WithConnections(8), WithDirectWrite(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for off := int64(0); off < size; off += 64 * MiB {
+		n := 64 * MiB
+		if off+n > size {
+			n = size - off
+		}
+		checkRange(t, f, off, n)
+	}
+}
+
+//TestRangeIgnored downloads from a server which tells that it supports ranges but sends the whole file for them
+func TestRangeIgnored(t *testing.T) {
+
+	size := 2*MiB + 99
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		if r.Method == http.MethodHead {
+			return
+		}
+		io.Copy(w, &virtualFile{size: size})
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, _ := f.Stat()
+	if fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 0, size)
+}
+
+//TestRangeMismatch checks that a partial response for another range than the one asked is not written to the file
+func TestRangeMismatch(t *testing.T) {
+
+	size := 2 * MiB
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//shift the range by one byte, the probe for the first byte is answered correctly
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil && end > 0 {
+			r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start+1, end))
+		}
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sum.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Content-Range") {
+		t.Fatalf("Run() = %v, want Content-Range error", err)
+	}
+
+	if fileExists(out) {
+		t.Fatal("output file is created")
+	}
+}
+
+//newETagServer serves a virtual file with range support and the ETag returned by etag
+func newETagServer(t *testing.T, size int64, etag func() string) *httptest.Server {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag())
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+//TestResumeRemoteChanged checks that a partial download of an older version of the file is downloaded again instead of resumed,
+//unless the policy is to always resume. The new version can also have another size so the ranges do not match it
+func TestResumeRemoteChanged(t *testing.T) {
+
+	oldSize := 2 * MiB
+
+	for _, size := range []int64{oldSize, 3 * MiB} {
+
+		srv := newETagServer(t, size, func() string { return `"v2"` })
+
+		for _, policy := range []ResumePolicy{RESUME_AUTO, RESUME_ALWAYS, RESUME_NEVER, RESUME_ASK} {
+			t.Run(fmt.Sprintf("%s %d", policy, size), func(t *testing.T) {
+
+				dir := t.TempDir()
+				out := filepath.Join(dir, "file.bin")
+
+				//first half of the old version
+				if err := os.WriteFile(filepath.Join(dir, ".file.bin"), bytes.Repeat([]byte{0xff}, int(MiB)), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				saveMeta(t, filepath.Join(dir, ".file.bin.summon.meta"), meta{
+					URL:         srv.URL + "/file.bin",
+					FinalURL:    srv.URL + "/file.bin",
+					Size:        oldSize,
+					Direct:      true,
+					Connections: 2,
+					Chunks:      []metaChunk{{Start: 0, End: MiB - 1, Done: MiB}, {Start: MiB, End: oldSize - 1, Done: 0}},
+					ETag:        `"v1"`,
+				})
+
+				confirm := func(path string) (bool, error) {
+					t.Fatal("asked to resume a changed file")
+					return true, nil
+				}
+
+				sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2), WithResumePolicy(policy), WithResumeConfirm(confirm))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = sum.Run(context.Background())
+
+				if policy == RESUME_ALWAYS {
+					if !errors.Is(err, ErrRemoteChanged) {
+						t.Fatalf("Run() = %v, want %v", err, ErrRemoteChanged)
+					}
+					if !fileExists(filepath.Join(dir, ".file.bin.summon.meta")) {
+						t.Fatal("incomplete download is deleted")
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				f, err := os.Open(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+
+				if fi, _ := f.Stat(); fi.Size() != size {
+					t.Fatalf("file size is %d, want %d", fi.Size(), size)
+				}
+
+				checkRange(t, f, 0, size)
+			})
+		}
+	}
+}
+
+//TestResumeOtherURL checks that a partial file is not resumed from another url unless only the query differs and the server can tell
+//that the file has not changed, the redirect target can differ
+func TestResumeOtherURL(t *testing.T) {
+
+	size := 2 * MiB
+	plain := newRangeServer(t, size, true)
+	tagged := newETagServer(t, size, func() string { return `"v1"` })
+
+	tests := map[string]struct {
+		srv            *httptest.Server
+		started, final string
+		passed         string
+		etag           string
+		resumed        bool
+	}{
+		"other path":            {srv: plain, started: "/old.bin", passed: "/file.bin"},
+		"other query":           {srv: plain, started: "/file.bin?sig=1", passed: "/file.bin?sig=2"},
+		"other query with etag": {srv: tagged, started: "/file.bin?sig=1", passed: "/file.bin?sig=2", etag: `"v1"`, resumed: true},
+		"other path with etag":  {srv: tagged, started: "/old.bin", passed: "/file.bin", etag: `"v1"`},
+		"other redirect target": {srv: plain, started: "/file.bin", final: "/cdn/file.bin", passed: "/file.bin", resumed: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+
+			dir := t.TempDir()
+			out := filepath.Join(dir, "file.bin")
+
+			//first half is not the content of the file so that a resume can be told from a new download
+			if err := os.WriteFile(filepath.Join(dir, ".file.bin"), bytes.Repeat([]byte{0xff}, int(MiB)), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			final := tt.final
+			if final == "" {
+				final = tt.started
+			}
+
+			saveMeta(t, filepath.Join(dir, ".file.bin.summon.meta"), meta{
+				URL:         tt.srv.URL + tt.started,
+				FinalURL:    tt.srv.URL + final,
+				Size:        size,
+				Direct:      true,
+				Connections: 2,
+				Chunks:      []metaChunk{{Start: 0, End: MiB - 1, Done: MiB}, {Start: MiB, End: size - 1, Done: 0}},
+				ETag:        tt.etag,
+			})
+
+			sum, err := New(tt.srv.URL+tt.passed, WithOutput(out), WithConnections(2), WithResumePolicy(RESUME_AUTO))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sum.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			first := make([]byte, 1)
+			f.ReadAt(first, 0)
+
+			if resumed := first[0] == 0xff; resumed != tt.resumed {
+				t.Fatalf("resumed = %v, want %v", resumed, tt.resumed)
+			}
+
+			checkRange(t, f, MiB, size-MiB)
+		})
+	}
+}
+
+func TestParseResumePolicy(t *testing.T) {
+
+	for _, name := range []string{"auto", "always", "never", "ask", "ASK"} {
+		rp, err := ParseResumePolicy(name)
+		if err != nil || !strings.EqualFold(rp.String(), name) {
+			t.Errorf("ParseResumePolicy(%q) = %v, %v", name, rp, err)
+		}
+	}
+
+	if _, err := ParseResumePolicy("sometimes"); err == nil {
+		t.Error("ParseResumePolicy(\"sometimes\") did not fail")
+	}
+}
+
+//TestRemoteChangedWhileDownloading checks that If-Range is sent and the download stops when the file changes after the probe
+func TestRemoteChangedWhileDownloading(t *testing.T) {
+
+	size := 2 * MiB
+	var requests int32
+
+	srv := newETagServer(t, size, func() string {
+		//the probe sees the first version, the ranges are asked for the second
+		if atomic.AddInt32(&requests, 1) == 1 {
+			return `"v1"`
+		}
+		return `"v2"`
+	})
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("Run() = %v, want %v", err, ErrRemoteChanged)
+	}
+
+	if fileExists(out) {
+		t.Fatal("output file is created")
+	}
+}
+
+//TestRunClosesConnections checks that the kept alive connections are closed when Run returns
+func TestRunClosesConnections(t *testing.T) {
+
+	var open int32
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: MiB})
+	}))
+	srv.Config.ConnState = func(c net.Conn, cs http.ConnState) {
+		switch cs {
+		case http.StateNew:
+			atomic.AddInt32(&open, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt32(&open, -1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(filepath.Join(t.TempDir(), "file.bin")), WithConnections(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	//the server sees the close a moment later
+	for i := 0; i < 100 && atomic.LoadInt32(&open) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&open); n != 0 {
+		t.Fatalf("%d connections are open after Run returned", n)
+	}
+}
+
+//TestProbeMethod checks that the method passed is used only for downloading, the probe falls back to GET
+func TestProbeMethod(t *testing.T) {
+
+	var probes, posts int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		case r.Method == http.MethodGet && r.Header.Get("Range") == "bytes=0-0":
+			atomic.AddInt32(&probes, 1)
+		case r.Method == http.MethodPost:
+			atomic.AddInt32(&posts, 1)
+		default:
+			t.Errorf("unexpected %v request for %v", r.Method, r.Header.Get("Range"))
+		}
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: MiB})
+	}))
+	defer srv.Close()
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(filepath.Join(t.TempDir(), "file.bin")), WithConnections(2), WithMethod("post"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if probes != 1 || posts == 0 {
+		t.Fatalf("got %d GET probes and %d POST requests, want 1 probe and the ranges with POST", probes, posts)
+	}
+}
+
+//TestProbeTimeout checks that the probe requests wait as long as the response timeout, not more and not less
+func TestProbeTimeout(t *testing.T) {
+
+	tests := map[string]struct {
+		headDelay time.Duration
+		response  time.Duration
+		gets      int32 //GET probes, HEAD is used when it answers in time
+	}{
+		"head times out": {headDelay: 300 * time.Millisecond, response: 100 * time.Millisecond, gets: 1},
+		"slow head":      {headDelay: 5500 * time.Millisecond, response: 10 * time.Second, gets: 0},
+	}
+
+	for name, tt := range tests {
+
+		if testing.Short() && tt.headDelay > time.Second {
+			continue
+		}
+
+		var gets int32
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodHead:
+				select {
+				case <-time.After(tt.headDelay):
+				case <-r.Context().Done():
+					return
+				}
+			case r.Header.Get("Range") == "bytes=0-0":
+				atomic.AddInt32(&gets, 1)
+			}
+			http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: MiB})
+		}))
+
+		sum, err := New(srv.URL+"/file.bin", WithOutput(filepath.Join(t.TempDir(), "file.bin")), WithTimeouts(0, tt.response))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sum.Run(context.Background()); err != nil {
+			t.Errorf("%s : Run() = %v", name, err)
+		}
+
+		if gets != tt.gets {
+			t.Errorf("%s : got %d GET probes, want %d", name, gets, tt.gets)
+		}
+
+		srv.Close()
+	}
+}
+
+//newChunkedServer serves a virtual file without Content-Length and ignores the ranges, like a file generated while it is sent.
+//The response stops after stopAt bytes until the client goes away, when ranges returns true the file is served with its size and ranges
+func newChunkedServer(t *testing.T, size, stopAt int64, ranges func() bool) *httptest.Server {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ranges() {
+			http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+			return
+		}
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		vf := &virtualFile{size: size}
+		buf := make([]byte, 32*1024)
+		for sent := int64(0); sent < stopAt; {
+			n, err := vf.Read(buf)
+			if err != nil {
+				return
+			}
+			w.Write(buf[:n])
+			//the response is chunked as it is flushed before the handler returns
+			w.(http.Flusher).Flush()
+			sent += int64(n)
+		}
+
+		<-r.Context().Done()
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+//TestDownloadUnknownSize downloads a chunked response which does not tell the size
+func TestDownloadUnknownSize(t *testing.T) {
+
+	size := 3*MiB + 11
+	srv := newChunkedServer(t, size, size+1, func() bool { return false })
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+
+	sum, err := New(srv.URL+"/file.bin", WithOutput(out), WithConnections(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if sum.Size() != size || len(sum.scheduler.segments) != 1 || sum.scheduler.segments[0].end != size-1 {
+		t.Fatalf("size is %d in %d segments, want %d in one segment", sum.Size(), len(sum.scheduler.segments), size)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if fi, _ := f.Stat(); fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 0, size)
+}
+
+//TestResumeUnknownSize stops a download of unknown size and resumes it once the server sends the size and supports ranges,
+//the bytes already downloaded are not asked again and what is left is cut across the connections
+func TestResumeUnknownSize(t *testing.T) {
+
+	size := 4 * MiB
+	stopAt := MiB
+
+	var ranges int32
+	var mu sync.Mutex
+	var asked []string
+
+	srv := newChunkedServer(t, size, stopAt, func() bool { return atomic.LoadInt32(&ranges) == 1 })
+
+	//records the ranges asked once the server supports them
+	recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&ranges) == 1 && r.Method == http.MethodGet {
+			mu.Lock()
+			asked = append(asked, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer recorder.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "file.bin")
+	part := filepath.Join(dir, ".file.bin.sump0")
+
+	sum, err := New(recorder.URL+"/file.bin", WithOutput(out), WithConnections(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//stop once the server has sent what it can
+	go func() {
+		for fileSizeOrZero(part) < stopAt && ctx.Err() == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	if err := sum.Run(ctx); err != ErrGracefulShutdown {
+		t.Fatalf("Run() = %v, want %v", err, ErrGracefulShutdown)
+	}
+
+	m, err := readMeta(filepath.Join(dir, ".file.bin.summon.meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Size != -1 || len(m.Chunks) != 1 || m.Chunks[0].End != UNKNOWN_END {
+		t.Fatalf("meta file = %+v, want one chunk of unknown end", m)
+	}
+
+	atomic.StoreInt32(&ranges, 1)
+
+	sum, err = New(recorder.URL+"/file.bin", WithOutput(out), WithConnections(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sum.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var downloads int
+	for _, r := range asked {
+		if r == "bytes=0-0" {
+			continue
+		}
+		var start, end int64
+		if _, err := fmt.Sscanf(r, "bytes=%d-%d", &start, &end); err != nil || start < stopAt {
+			t.Fatalf("range %q is asked again, %d bytes were downloaded", r, stopAt)
+		}
+		downloads++
+	}
+
+	if downloads < 4 || len(sum.scheduler.segments) < 4 {
+		t.Fatalf("rest of the file is downloaded in %d segments with %d requests, want at least 4", len(sum.scheduler.segments), downloads)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if fi, _ := f.Stat(); fi.Size() != size {
+		t.Fatalf("file size is %d, want %d", fi.Size(), size)
+	}
+
+	checkRange(t, f, 0, size)
+}
+
+//fileSizeOrZero returns the size of the file, 0 when it does not exist yet
+func fileSizeOrZero(path string) int64 {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
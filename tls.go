@@ -0,0 +1,168 @@
+package summon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//tlsVersions maps the versions accepted by WithTLSMinVersion to the tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//tlsOptions are the TLS settings passed by the user, they are converted to the tls config of the transport in New
+type tlsOptions struct {
+	caCert     string   //PEM file with the CAs used instead of the system CAs
+	caPath     string   //directory with the PEM files of the CAs used instead of the system CAs
+	cert       string   //PEM file with the client certificate
+	key        string   //PEM file with the key of the client certificate, empty means it is in the cert file
+	pins       [][]byte //sha256 of the public keys, the server should have one of them
+	minVersion uint16
+	insecure   bool //do not verify the certificate of the server
+}
+
+//tlsConfig returns the tls config of the transport as per the options
+func (sum *Downloader) tlsConfig() (*tls.Config, error) {
+
+	opts := sum.tls
+	config := &tls.Config{
+		MinVersion:         opts.minVersion,
+		InsecureSkipVerify: opts.insecure,
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if opts.caCert != "" || opts.caPath != "" {
+		pool, err := loadCAs(opts.caCert, opts.caPath)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.cert != "" {
+		key := opts.key
+		if key == "" {
+			key = opts.cert
+		}
+		cert, err := tls.LoadX509KeyPair(opts.cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading client certificate : %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.pins) > 0 {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPin(cs, opts.pins)
+		}
+	}
+
+	return config, nil
+}
+
+//loadCAs returns the pool with the CAs in the file and the directory
+func loadCAs(file, dir string) (*x509.CertPool, error) {
+
+	pool := x509.NewCertPool()
+	files := []string{}
+
+	if file != "" {
+		files = append(files, file)
+	}
+
+	if dir != "" {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading CA directory : %v", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+
+	found := false
+	for _, f := range files {
+
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading CA file : %v", err)
+		}
+
+		//directories usually have other files too, only the named file must have certificates
+		if !pool.AppendCertsFromPEM(data) {
+			if f == file {
+				return nil, fmt.Errorf("no certificates found in CA file : %v", f)
+			}
+			continue
+		}
+
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no certificates found in CA directory : %v", dir)
+	}
+
+	return pool, nil
+}
+
+//parsePins parses the pins in sha256//base64;sha256//base64 format
+func parsePins(s string) ([][]byte, error) {
+
+	pins := [][]byte{}
+
+	for _, p := range strings.Split(s, ";") {
+
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(p, "sha256//") {
+			return nil, fmt.Errorf("pinned public key should be in sha256//base64 format, got : %v", p)
+		}
+
+		pin, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p, "sha256//"))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 pinned public key : %v", p)
+		}
+
+		pins = append(pins, pin)
+	}
+
+	return pins, nil
+}
+
+//verifyPin checks that the public key of the server certificate is one of the pinned keys
+func verifyPin(cs tls.ConnectionState, pins [][]byte) error {
+
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server did not send a certificate")
+	}
+
+	spki := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+
+	for _, pin := range pins {
+		if bytes.Equal(pin, spki[:]) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("public key of %v does not match the pinned public key, got sha256//%v", cs.ServerName, base64.StdEncoding.EncodeToString(spki[:]))
+}
@@ -0,0 +1,134 @@
+package summon
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePins(t *testing.T) {
+
+	a := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	b := base64.StdEncoding.EncodeToString(append(make([]byte, sha256.Size-1), 1))
+
+	tests := map[string]struct {
+		pins string
+		want int
+		ok   bool
+	}{
+		"one":          {pins: "sha256//" + a, want: 1, ok: true},
+		"two":          {pins: "sha256//" + a + ";sha256//" + b, want: 2, ok: true},
+		"spaces":       {pins: " sha256//" + a + " ; ", want: 1, ok: true},
+		"empty":        {pins: "", want: 0, ok: true},
+		"no prefix":    {pins: a},
+		"sha1":         {pins: "sha1//" + a},
+		"not base64":   {pins: "sha256//not base64"},
+		"wrong length": {pins: "sha256//" + base64.StdEncoding.EncodeToString(make([]byte, 20))},
+	}
+
+	for name, tt := range tests {
+
+		pins, err := parsePins(tt.pins)
+
+		if (err == nil) != tt.ok || len(pins) != tt.want {
+			t.Errorf("%s : parsePins(%q) = %d pins, %v, want %d", name, tt.pins, len(pins), err, tt.want)
+		}
+	}
+}
+
+//newTLSServer serves a virtual file over TLS, config can limit the versions of the server. The CA of the server is written to a PEM file in dir
+func newTLSServer(t *testing.T, size int64, config *tls.Config, dir string) (*httptest.Server, string) {
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, &virtualFile{size: size})
+	}))
+	srv.TLS = config
+	//the handshakes which fail on purpose are not logged
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, ca
+}
+
+func TestTLS(t *testing.T) {
+
+	size := MiB
+	dir := t.TempDir()
+
+	srv, ca := newTLSServer(t, size, nil, dir)
+
+	//a directory of CAs usually has other files too
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spki := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	pin := "sha256//" + base64.StdEncoding.EncodeToString(spki[:])
+	otherPin := "sha256//" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	old, oldCA := newTLSServer(t, size, &tls.Config{MaxVersion: tls.VersionTLS12}, t.TempDir())
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		url  string
+		opts []Option
+		want string //part of the error, empty when it should succeed
+	}{
+		"system cas":            {url: srv.URL, want: "certificate"},
+		"ca file":               {url: srv.URL, opts: []Option{WithCACert(ca)}},
+		"ca directory":          {url: srv.URL, opts: []Option{WithCAPath(dir)}},
+		"ca file no certs":      {url: srv.URL, opts: []Option{WithCACert(empty)}, want: "no certificates found in CA file"},
+		"empty ca dir":          {url: srv.URL, opts: []Option{WithCAPath(t.TempDir())}, want: "no certificates found in CA directory"},
+		"missing ca":            {url: srv.URL, opts: []Option{WithCACert(filepath.Join(dir, "missing.pem"))}, want: "error while reading CA file"},
+		"insecure":              {url: srv.URL, opts: []Option{WithInsecure(true)}},
+		"pin":                   {url: srv.URL, opts: []Option{WithCACert(ca), WithPinnedPublicKey(otherPin + ";" + pin)}},
+		"pin insecure":          {url: srv.URL, opts: []Option{WithInsecure(true), WithPinnedPublicKey(pin)}},
+		"pin mismatch":          {url: srv.URL, opts: []Option{WithCACert(ca), WithPinnedPublicKey(otherPin)}, want: "does not match the pinned public key, got " + pin},
+		"pin mismatch insecure": {url: srv.URL, opts: []Option{WithInsecure(true), WithPinnedPublicKey(otherPin)}, want: "does not match the pinned public key"},
+		"tls 1.2 server":        {url: old.URL, opts: []Option{WithCACert(oldCA), WithTLSMinVersion("1.2")}},
+		"tls 1.3 minimum":       {url: old.URL, opts: []Option{WithCACert(oldCA), WithTLSMinVersion("1.3")}, want: "protocol version"},
+	}
+
+	for name, tt := range tests {
+
+		opts := append([]Option{WithOutput(filepath.Join(t.TempDir(), "file.bin")), WithRetries(0)}, tt.opts...)
+
+		//the CAs are loaded by New
+		sum, err := New(tt.url+"/file.bin", opts...)
+		if err == nil {
+			err = sum.Run(context.Background())
+		}
+
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s : Run() = %v", name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s : Run() = %v, want %q in it", name, err, tt.want)
+		}
+	}
+
+	if _, err := New(srv.URL, WithTLSMinVersion("1.4")); err == nil {
+		t.Error("TLS version 1.4 is accepted")
+	}
+}
@@ -0,0 +1,73 @@
+package summon
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DEFAULT_CONNECT_TIMEOUT  = 30 * time.Second //for the dial and the TLS handshake
+	DEFAULT_RESPONSE_TIMEOUT = 30 * time.Second //wait for the headers of the response after sending the request
+	KEEP_ALIVE               = 30 * time.Second
+	IDLE_CONN_TIMEOUT        = 90 * time.Second
+)
+
+//setClient creates the client used by all the requests of the download, it is created again if the number of connections changes.
+//The connections are kept alive and reused by the segments
+func (sum *Downloader) setClient() error {
+
+	tlsConfig, err := sum.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   sum.connectTimeout,
+		KeepAlive: KEEP_ALIVE,
+	}
+
+	//one more for the probe
+	maxConns := int(sum.concurrency) + 1
+
+	transport := &http.Transport{
+		Proxy:                 sum.proxyFor,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   sum.connectTimeout,
+		ResponseHeaderTimeout: sum.responseTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxConnsPerHost:       maxConns,
+		MaxIdleConnsPerHost:   maxConns,
+		MaxIdleConns:          maxConns,
+		IdleConnTimeout:       IDLE_CONN_TIMEOUT,
+		ForceAttemptHTTP2:     sum.http2,
+		DisableCompression:    true, //the file is saved as sent by the server, otherwise gzip responses would be decompressed
+	}
+
+	//every range gets its own connection with HTTP/1.1
+	if !sum.http2 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	onConnectResponse(transport)
+
+	sum.closeIdleConnections()
+
+	sum.transport = transport
+	sum.client = &http.Client{
+		Transport:     transport,
+		Jar:           sum.cookieJar,
+		CheckRedirect: sum.checkRedirect,
+	}
+
+	return nil
+}
+
+//closeIdleConnections closes the kept alive connections of the transport
+func (sum *Downloader) closeIdleConnections() {
+	if sum.transport != nil {
+		sum.transport.CloseIdleConnections()
+	}
+}
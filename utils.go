@@ -1,28 +1,15 @@
-package main
+package summon
 
 import (
 	"bytes"
 	"encoding/base64"
-	"flag"
 	"fmt"
-	"io"
-	"log"
-	"math"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"runtime"
 	"strconv"
-	"strings"
 	"time"
 )
 
-type arguments struct {
-	connections int64
-	help        bool
-	outputFile  string
-	verbose     bool
-}
-
 func fileExists(fname string) bool {
 
 	if _, err := os.Stat(fname); !os.IsNotExist(err) {
@@ -33,100 +20,46 @@ func fileExists(fname string) bool {
 
 }
 
-func parseFlags(args *arguments) {
-
-	flag.Int64Var(&args.connections, "c", 0, "number of concurrent connections")
-	flag.BoolVar(&args.help, "h", false, "displays available flags")
-	flag.BoolVar(&args.verbose, "v", false, "enables debug logs")
-	flag.StringVar(&args.outputFile, "o", "", "output path of downloaded file, default is same directory.")
-	flag.Parse()
-
-}
-
-func encode(b []byte, w io.Writer) error {
-
-	enc := base64.NewEncoder(base64.StdEncoding, w)
-	defer enc.Close()
-	_, err := enc.Write(b)
-	if err != nil {
-		return err
-	}
-
-	return nil
-
-}
-
 func decode(b []byte) ([]byte, error) {
 
 	r := bytes.NewReader(b)
-	out := make([]byte, len(b))
 	dec := base64.NewDecoder(base64.StdEncoding, r)
-	n, err := dec.Read(out)
-	if err != nil {
-		return out, err
-	}
 
-	return out[:n], nil
+	//a single read can return only a part of the decoded data
+	return ioutil.ReadAll(dec)
 }
 
 func parseint64(s ...string) ([]int64, error) {
 
 	var err error
-	var r uint64
+	var r int64
 	var ret []int64
 
 	for _, v := range s {
-		r, err = strconv.ParseUint(v, 10, 32)
+		//sizes can be larger than 4 GiB so all 64 bits are needed
+		r, err = strconv.ParseInt(v, 10, 64)
 		if err != nil {
-			log.Println(err)
 			return ret, err
 		}
-		ret = append(ret, int64(r))
+		if r < 0 {
+			return ret, fmt.Errorf("negative value : %v", v)
+		}
+		ret = append(ret, r)
 	}
 
 	return ret, nil
 }
 
-func startTimer(s string, args ...interface{}) func() {
+func (sum *Downloader) startTimer(s string, args ...interface{}) func() {
 	startTime := time.Now()
 	str := fmt.Sprintf(s, args...)
 	return func() {
-		LogWriter.Printf(str+" %v", time.Since(startTime))
-	}
-}
-
-func getProgressSize() int {
-	cmd := exec.Command("stty", "size")
-	cmd.Stdin = os.Stdin
-	out, err := cmd.Output()
-
-	if err != nil {
-		LogWriter.Printf("error occured while size command : %v", err)
-		return DEFAULT_PROGRESS_SIZE
-	}
-
-	data := strings.Split(strings.TrimRight(string(out), "\n"), " ")
-	if len(data) < 1 {
-		return DEFAULT_PROGRESS_SIZE
-	}
-
-	i, err := strconv.Atoi(data[1])
-	if err != nil {
-		LogWriter.Printf("error occured while converting str to int : %v", err)
-		return DEFAULT_PROGRESS_SIZE
-	}
-
-	//35 percent of the available terminal size
-	return int(math.Round(0.35 * float64(i)))
-}
-
-func printWarnings() {
-	if runtime.GOOS == "windows" {
-		log.Println("WARNING: It may not work as expected on windows")
+		sum.debug.Printf(str+" %v", time.Since(startTime))
 	}
 }
 
-func humanSizeFromBytes(b int64) string {
+//HumanSizeFromBytes returns the size in human readable format like 1.5 MiB
+func HumanSizeFromBytes(b int64) string {
 
 	const unit = 1024
 	if b < unit {